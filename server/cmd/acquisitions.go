@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Parses a bound of a date window, either a day such as 2024-05-01 or a
+// time such as 2024-05-01T12:00:00Z. A day stands for its start, or for the
+// start of the next day when it is the end of the window, so the window
+// "2024-05-01 to 2024-05-31" covers all of May. Days are in UTC.
+func parseWindowBound(name, value string, end bool) (time.Time, error) {
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		if end {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("%s must be a date such as 2024-05-01 or a time such as 2024-05-01T12:00:00Z, not %q", name, value)
+	}
+	return t.UTC(), nil
+}
+
+// Parses ?from= and ?to= into the window [from, to). From is required, to
+// defaults to now.
+func parseAcquisitionWindow(from, to string) (time.Time, time.Time, error) {
+	if from == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("from is required, e.g. ?from=2024-05-01")
+	}
+	start, err := parseWindowBound("from", from, false)
+	if err != nil {
+		return start, start, err
+	}
+	end := time.Now().UTC()
+	if to != "" {
+		if end, err = parseWindowBound("to", to, true); err != nil {
+			return start, end, err
+		}
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("from must be before to")
+	}
+	return start, end, nil
+}
+
+// Matches the books added in [from, to). Like addedAt, documents stored
+// before CreatedAt existed are matched by the creation time encoded in
+// their ObjectID, which is precise to the second.
+func acquisitionFilter(from, to time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"createdat": bson.M{"$gte": from, "$lt": to}},
+		bson.M{
+			"createdat": bson.M{"$exists": false},
+			"_id": bson.M{
+				"$gte": primitive.NewObjectIDFromTimestamp(from),
+				"$lt":  primitive.NewObjectIDFromTimestamp(to),
+			},
+		},
+	}}
+}
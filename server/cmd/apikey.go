@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Header server-to-server clients send their API key in
+const apiKeyHeader = "X-API-Key"
+
+// Parses API_KEYS: a comma-separated list of keys that are all valid at the
+// same time, so a key can be rotated by adding the new one first and
+// removing the old one once no client sends it anymore.
+func parseAPIKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Reports whether given is one of keys. Every key is compared, in constant
+// time, so the answer does not tell how much of a key was right.
+func validAPIKey(given string, keys []string) bool {
+	valid := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// Requires one of keys in X-API-Key for the requests below prefix that
+// change something. Reads, and the routes in readOnly that are sent as
+// POST but store nothing, stay open. The admin token is accepted instead of
+// a key, so admins do not need both.
+func apiKeyMiddleware(keys []string, adminToken, prefix string, readOnly ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if !strings.HasPrefix(c.Path(), prefix) || slices.Contains(readOnly, c.Path()) || isAdmin(c, adminToken) {
+				return next(c)
+			}
+			given := c.Request().Header.Get(apiKeyHeader)
+			if given == "" {
+				return respondProblem(c, 401, newProblem("api_key_required"))
+			}
+			if !validAPIKey(given, keys) {
+				logger.WarnContext(c.Request().Context(), "invalid api key", "route", c.Path(), "client", c.RealIP())
+				return respondProblem(c, 401, newProblem("api_key_invalid"))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Actions recorded in the history collection
+const (
+	actionCreated = "created"
+	actionUpdated = "updated"
+	actionDeleted = "deleted"
+)
+
+// One entry of the append-only change log. Before is empty for created
+// books and After for deleted ones.
+type auditEntry struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	BookID primitive.ObjectID `bson:"bookid"`
+	Action string             `bson:"action"`
+	At     time.Time          `bson:"at"`
+	User   string             `bson:"user"`
+	Before *BookStore         `bson:"before,omitempty"`
+	After  *BookStore         `bson:"after,omitempty"`
+}
+
+// How an audit entry is sent to the client
+type historyEntry struct {
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+	User   string    `json:"user"`
+	Before *Book     `json:"before,omitempty"`
+	After  *Book     `json:"after,omitempty"`
+}
+
+// Returns the collection holding the change log, next to the books
+func historyCollection(coll *mongo.Collection) *mongo.Collection {
+	return coll.Database().Collection("history")
+}
+
+// The history of a book is always looked up by its id, in order
+func prepareHistory(hist *mongo.Collection) error {
+	_, err := hist.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "bookid", Value: 1}, {Key: "at", Value: 1}},
+	})
+	return err
+}
+
+// Appends an entry for a change of a book and notifies webhooks. It is
+// called right after the change itself succeeded; a failure to write the
+// entry is logged, as the change cannot be taken back anymore at that point,
+// and the entry is written even if the client has gone away meanwhile.
+func recordAudit(ctx context.Context, hist *mongo.Collection, webhooks *webhookNotifier, action string, user string, before, after *BookStore) {
+	entry := auditEntry{
+		Action: action,
+		At:     time.Now().UTC(),
+		User:   user,
+		Before: before,
+		After:  after,
+	}
+	if before != nil {
+		entry.BookID = before.ID
+	} else if after != nil {
+		entry.BookID = after.ID
+	}
+	if _, err := hist.InsertOne(context.WithoutCancel(ctx), entry); err != nil {
+		logger.ErrorContext(ctx, "could not record audit entry",
+			"action", action, "book", entry.BookID.Hex(), "error", err)
+	}
+	if after != nil {
+		webhooks.notify(ctx, action, *after)
+	} else if before != nil {
+		webhooks.notify(ctx, action, *before)
+	}
+}
+
+// Returns when the book was deleted according to the history, or
+// mongo.ErrNoDocuments if there is no record of its deletion. Deleted books
+// are removed from the collection, so their history is the tombstone that
+// tells them apart from ids that never existed.
+func findDeletion(ctx context.Context, hist *mongo.Collection, id primitive.ObjectID) (time.Time, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "at", Value: -1}})
+	var entry auditEntry
+	err := hist.FindOne(ctx, bson.M{"bookid": id, "action": actionDeleted}, opts, findOneTimeLimit(ctx)).Decode(&entry)
+	return entry.At, err
+}
+
+// Returns the recorded changes of a book, oldest first
+func findHistory(ctx context.Context, hist *mongo.Collection, id primitive.ObjectID) ([]historyEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := hist.Find(ctx, bson.M{"bookid": id}, opts, findTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var entries []auditEntry
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	history := []historyEntry{}
+	for _, entry := range entries {
+		view := historyEntry{Action: entry.Action, At: entry.At, User: entry.User}
+		if entry.Before != nil {
+			before := convertToBook(*entry.Before)
+			view.Before = &before
+		}
+		if entry.After != nil {
+			after := convertToBook(*entry.After)
+			view.After = &after
+		}
+		history = append(history, view)
+	}
+	return history, nil
+}
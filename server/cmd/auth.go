@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Reports whether the request carries the admin token configured through
+// ADMIN_TOKEN, sent as "Authorization: Bearer <token>". When no token is
+// configured nobody is treated as an admin.
+func isAdmin(c echo.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	given, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Name recorded as the author of a change. Requests are not authenticated
+// per person, so this is the name the client sends in X-User, falling back
+// to the client's address. Anybody can send any name, so it must not decide
+// who may do what; locks go by lockOwner instead.
+func requestActor(c echo.Context) string {
+	if user := strings.TrimSpace(c.Request().Header.Get("X-User")); user != "" {
+		return user
+	}
+	return c.RealIP()
+}
+
+// Holder of the edit locks a request takes, releases or is let past. It
+// comes from a credential the server checked: "admin" for the admin token,
+// or a fingerprint of the API key, so the key is never shown to the
+// clients a lock turns away. Requests without a credential hold no lock
+// and get "".
+func (cfg config) lockOwner(c echo.Context) string {
+	if isAdmin(c, cfg.AdminToken) {
+		return "admin"
+	}
+	if key := c.Request().Header.Get(apiKeyHeader); key != "" && validAPIKey(key, cfg.APIKeys) {
+		sum := sha256.Sum256([]byte(key))
+		return "api key " + hex.EncodeToString(sum[:4])
+	}
+	return ""
+}
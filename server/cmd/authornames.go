@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Orders of the parts of an author's name
+const (
+	conventionFirstLast = "first-last" // Mary Wollstonecraft Shelley
+	conventionLastFirst = "last-first" // Shelley, Mary Wollstonecraft
+)
+
+// Generational suffixes, which stay at the end whatever the order is
+var nameSuffixes = map[string]bool{
+	"jr": true, "jr.": true, "sr": true, "sr.": true,
+	"ii": true, "iii": true, "iv": true,
+}
+
+func isNameSuffix(s string) bool {
+	return nameSuffixes[strings.ToLower(s)]
+}
+
+// The parts of a person's name. Given holds all given names, Family the
+// last word of a "First Last" name together with the particles before it,
+// such as "van Beethoven", or everything before the comma.
+type personName struct {
+	Given  string
+	Family string
+	Suffix string
+}
+
+// "and" or "&" between the last two of several authors
+var authorSeparator = regexp.MustCompile(`(?i)\s+(?:and|&)\s+`)
+
+// Splits the author of a book into the people named in it, such as "Mary
+// Shelley, Percy Bysshe Shelley and Lord Byron" the way bibAuthors writes
+// it. A trailing "et al." is reported separately. Commas only separate
+// names that have several words each, so "Shelley, Mary and Percy
+// Shelley" is two people.
+func splitAuthors(author string) (names []string, etAl bool) {
+	author = strings.Join(strings.Fields(author), " ")
+	if trimmed, ok := strings.CutSuffix(author, " et al."); ok {
+		author, etAl = strings.TrimSuffix(trimmed, ","), true
+	}
+	parts := authorSeparator.Split(author, -1)
+	last := parts[len(parts)-1]
+	if head := parts[:len(parts)-1]; len(head) > 0 || etAl {
+		if len(head) == 0 {
+			head = []string{last}
+			last = ""
+		}
+		for _, part := range head {
+			names = append(names, splitAuthorList(part)...)
+		}
+	}
+	if last != "" {
+		names = append(names, last)
+	}
+	return names, etAl
+}
+
+// Splits "A B, C D" into its names unless a part is a single word or a
+// suffix, which makes it a "Last, First" name
+func splitAuthorList(list string) []string {
+	parts := strings.Split(list, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+		if !strings.Contains(parts[i], " ") || isNameSuffix(parts[i]) {
+			return []string{list}
+		}
+	}
+	return parts
+}
+
+// Splits a name written either as "First Last" or as "Last, First", each
+// optionally with a suffix: "Martin Luther King Jr.", "King, Martin
+// Luther, Jr.". Names of a single word only have a family name.
+func parsePersonName(name string) personName {
+	name = strings.Join(strings.Fields(name), " ")
+	if names, etAl := splitAuthors(name); len(names) > 1 || etAl {
+		// Several people are kept as they are
+		return personName{Family: name}
+	}
+	if strings.Contains(name, ",") {
+		parts := strings.Split(name, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		switch {
+		case len(parts) == 2 && isNameSuffix(parts[1]):
+			// "Martin Luther King, Jr." is a First Last name
+			person := parsePersonName(parts[0])
+			person.Suffix = parts[1]
+			return person
+		case len(parts) == 2:
+			return personName{Given: parts[1], Family: parts[0]}
+		case len(parts) == 3 && isNameSuffix(parts[2]):
+			return personName{Given: parts[1], Family: parts[0], Suffix: parts[2]}
+		}
+		// Anything else is not a single person's name, keep it
+		return personName{Family: name}
+	}
+
+	words := strings.Fields(name)
+	var person personName
+	if len(words) > 1 && isNameSuffix(words[len(words)-1]) {
+		person.Suffix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return person
+	}
+	// The family name starts at the first lower case word after the given
+	// names, as BibTeX reads a von part, or else is the last word
+	family := len(words) - 1
+	for i := 1; i < len(words)-1; i++ {
+		if startsLowerCase(words[i]) {
+			family = i
+			break
+		}
+	}
+	person.Family = strings.Join(words[family:], " ")
+	person.Given = strings.Join(words[:family], " ")
+	return person
+}
+
+// Writes the name in the given convention
+func (p personName) format(convention string) string {
+	if p.Given == "" {
+		return strings.TrimSpace(p.Family + " " + p.Suffix)
+	}
+	if convention == conventionLastFirst {
+		name := p.Family + ", " + p.Given
+		if p.Suffix != "" {
+			name += ", " + p.Suffix
+		}
+		return name
+	}
+	return strings.TrimSpace(p.Given + " " + p.Family + " " + p.Suffix)
+}
+
+// Brings an author's name into the given convention
+func reorderAuthor(name, convention string) string {
+	return parsePersonName(name).format(convention)
+}
+
+// A spelling of an author that normalizeAuthorNames changes
+type authorRename struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Books int64  `json:"books"`
+}
+
+type authorRenameReport struct {
+	DryRun     bool           `json:"dryRun"`
+	Convention string         `json:"convention"`
+	Changes    []authorRename `json:"changes"`
+	Updated    int64          `json:"updated"`
+}
+
+// Finds the authors whose name is not written in the convention yet and,
+// unless it is a dry run, renames them. Each renamed book gets an entry in
+// the history, the same as for any other update.
+func normalizeAuthorNames(ctx context.Context, coll, hist *mongo.Collection, webhooks *webhookNotifier, user, convention string, dryRun bool) (authorRenameReport, error) {
+	report := authorRenameReport{DryRun: dryRun, Convention: convention, Changes: []authorRename{}}
+	values, err := coll.Distinct(ctx, "bookauthor", bson.M{}, distinctTimeLimit(ctx))
+	if err != nil {
+		return report, err
+	}
+	var authors []string
+	for _, value := range values {
+		if author, ok := value.(string); ok && author != "" {
+			authors = append(authors, author)
+		}
+	}
+	sort.Strings(authors)
+
+	for _, author := range authors {
+		renamed := reorderAuthor(author, convention)
+		if renamed == author {
+			continue
+		}
+		count, err := countBooks(ctx, coll, bson.M{"bookauthor": author})
+		if err != nil {
+			return report, err
+		}
+		report.Changes = append(report.Changes, authorRename{From: author, To: renamed, Books: count})
+		if dryRun {
+			continue
+		}
+		updated, err := renameAuthor(ctx, coll, hist, webhooks, user, author, renamed)
+		report.Updated += updated
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func renameAuthor(ctx context.Context, coll, hist *mongo.Collection, webhooks *webhookNotifier, user, from, to string) (int64, error) {
+	cursor, err := coll.Find(ctx, bson.M{"bookauthor": from}, findTimeLimit(ctx))
+	if err != nil {
+		return 0, err
+	}
+	var books []BookStore
+	if err = cursor.All(ctx, &books); err != nil {
+		return 0, err
+	}
+	var updated int64
+	for _, before := range books {
+		after := before
+		after.BookAuthor = to
+		after, err = patchBook(ctx, coll, before.ID, after)
+		if err != nil {
+			return updated, fmt.Errorf("renaming the author of %s: %w", before.ID.Hex(), err)
+		}
+		updated++
+		recordAudit(ctx, hist, webhooks, actionUpdated, user, &before, &after)
+	}
+	return updated, nil
+}
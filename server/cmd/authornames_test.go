@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePersonName(t *testing.T) {
+	tests := []struct {
+		name string
+		want personName
+	}{
+		{"Mary Shelley", personName{Given: "Mary", Family: "Shelley"}},
+		{"Shelley, Mary", personName{Given: "Mary", Family: "Shelley"}},
+		{"Mary Wollstonecraft Shelley", personName{Given: "Mary Wollstonecraft", Family: "Shelley"}},
+		{"Shelley, Mary Wollstonecraft", personName{Given: "Mary Wollstonecraft", Family: "Shelley"}},
+		{"Homer", personName{Family: "Homer"}},
+		{"  Homer ", personName{Family: "Homer"}},
+		{"Ludwig van Beethoven", personName{Given: "Ludwig", Family: "van Beethoven"}},
+		{"van Beethoven, Ludwig", personName{Given: "Ludwig", Family: "van Beethoven"}},
+		{"Juana Inés de la Cruz", personName{Given: "Juana Inés", Family: "de la Cruz"}},
+		{"bell hooks", personName{Given: "bell", Family: "hooks"}},
+		{"Martin Luther King Jr.", personName{Given: "Martin Luther", Family: "King", Suffix: "Jr."}},
+		{"Martin Luther King, Jr.", personName{Given: "Martin Luther", Family: "King", Suffix: "Jr."}},
+		{"King, Martin Luther, Jr.", personName{Given: "Martin Luther", Family: "King", Suffix: "Jr."}},
+		{"  Mary \t Wollstonecraft   Shelley ", personName{Given: "Mary Wollstonecraft", Family: "Shelley"}},
+		{"Shelley ,  Mary", personName{Given: "Mary", Family: "Shelley"}},
+		{"Jr.", personName{Family: "Jr."}},
+		{"", personName{}},
+		// Not a single person, kept as it is
+		{"Smith, Jones, Brown", personName{Family: "Smith, Jones, Brown"}},
+		{"Douglas Preston and Lincoln Child", personName{Family: "Douglas Preston and Lincoln Child"}},
+		{"Mary Shelley et al.", personName{Family: "Mary Shelley et al."}},
+	}
+	for _, test := range tests {
+		if got := parsePersonName(test.name); got != test.want {
+			t.Errorf("parsePersonName(%q) = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReorderAuthor(t *testing.T) {
+	tests := []struct {
+		name, convention, want string
+	}{
+		{"Mary Shelley", conventionLastFirst, "Shelley, Mary"},
+		{"Shelley, Mary", conventionFirstLast, "Mary Shelley"},
+		{"Shelley, Mary", conventionLastFirst, "Shelley, Mary"},
+		{"Homer", conventionLastFirst, "Homer"},
+		{"Ludwig van Beethoven", conventionLastFirst, "van Beethoven, Ludwig"},
+		{"van Beethoven, Ludwig", conventionFirstLast, "Ludwig van Beethoven"},
+		{"Martin Luther King Jr.", conventionLastFirst, "King, Martin Luther, Jr."},
+		{"King, Martin Luther, Jr.", conventionFirstLast, "Martin Luther King Jr."},
+		{" Mary   Shelley ", conventionFirstLast, "Mary Shelley"},
+	}
+	for _, test := range tests {
+		if got := reorderAuthor(test.name, test.convention); got != test.want {
+			t.Errorf("reorderAuthor(%q, %s) = %q, want %q", test.name, test.convention, got, test.want)
+		}
+	}
+}
+
+func TestSplitAuthors(t *testing.T) {
+	tests := []struct {
+		author string
+		names  []string
+		etAl   bool
+	}{
+		{"Mary Shelley", []string{"Mary Shelley"}, false},
+		{"Shelley, Mary", []string{"Shelley, Mary"}, false},
+		{"King, Martin Luther, Jr.", []string{"King, Martin Luther, Jr."}, false},
+		{"Douglas Preston and Lincoln Child", []string{"Douglas Preston", "Lincoln Child"}, false},
+		{"Douglas Preston & Lincoln Child", []string{"Douglas Preston", "Lincoln Child"}, false},
+		{"Shelley, Mary and Percy Bysshe Shelley", []string{"Shelley, Mary", "Percy Bysshe Shelley"}, false},
+		{"Mary Shelley, Percy Bysshe Shelley and Lord Byron", []string{"Mary Shelley", "Percy Bysshe Shelley", "Lord Byron"}, false},
+		{"Mary Shelley et al.", []string{"Mary Shelley"}, true},
+		{"Mary Shelley, Percy Bysshe Shelley et al.", []string{"Mary Shelley", "Percy Bysshe Shelley"}, true},
+		{"Alexandra David-Néel", []string{"Alexandra David-Néel"}, false},
+	}
+	for _, test := range tests {
+		names, etAl := splitAuthors(test.author)
+		if !slices.Equal(names, test.names) || etAl != test.etAl {
+			t.Errorf("splitAuthors(%q) = %q, %v, want %q, %v", test.author, names, etAl, test.names, test.etAl)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The books of one author, as returned by /api/books/grouped-by-author
+type authorBooks struct {
+	Author string `json:"author"`
+	Books  []Book `json:"books"`
+}
+
+// Reduces an author's name to the form names are compared in: lower case,
+// without surrounding whitespace and with runs of whitespace collapsed, so
+// " edgar  allan POE" and "Edgar Allan Poe" are the same author.
+func normalizeAuthor(author string) string {
+	return strings.ToLower(strings.Join(strings.Fields(author), " "))
+}
+
+// Filter matching the books whose author normalizes to the same name. The
+// stored names are not normalized, so the comparison is a case-insensitive
+// regular expression that allows any whitespace between the words.
+func authorFilter(author string) bson.M {
+	return bson.M{"bookauthor": primitive.Regex{Pattern: authorPattern(author), Options: "i"}}
+}
+
+// The regular expression of authorFilter, to be matched case-insensitively
+func authorPattern(author string) string {
+	words := strings.Fields(author)
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	return `^\s*` + strings.Join(words, `\s+`) + `\s*$`
+}
+
+// Returns the books of the given author, ordered by year and name
+func findBooksByAuthor(ctx context.Context, coll *mongo.Collection, author string) ([]Book, error) {
+	opts := options.Find().SetSort(stableSort(bson.D{{Key: "bookyear", Value: 1}, {Key: "bookname", Value: 1}}))
+	return findBooks(ctx, coll, authorFilter(author), opts)
+}
+
+// Fetches at most limit books and groups them by their normalized author.
+// Each group is labelled with the spelling of its first book; groups and
+// the books within them are in alphabetical order.
+func groupBooksByAuthor(ctx context.Context, coll *mongo.Collection, limit int64) ([]authorBooks, error) {
+	opts := options.Find().
+		SetSort(stableSort(bson.D{{Key: "bookauthor", Value: 1}, {Key: "bookname", Value: 1}})).
+		SetLimit(limit)
+	books, err := findBooks(ctx, coll, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []authorBooks{}
+	index := map[string]int{}
+	for _, book := range books {
+		key := normalizeAuthor(book.Author)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, authorBooks{Author: strings.TrimSpace(book.Author)})
+		}
+		groups[i].Books = append(groups[i].Books, book)
+	}
+	return groups, nil
+}
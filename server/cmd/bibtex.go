@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Largest .bib file accepted by /api/books/import/bibtex
+const maxBibTeXBytes = 5 << 20
+
+// Entry types that describe a whole book. Articles, chapters and the like
+// are part of something else and are skipped by an import.
+var bibBookTypes = map[string]bool{
+	"book": true, "mvbook": true, "booklet": true, "manual": true,
+	"collection": true, "mvcollection": true, "proceedings": true,
+	"mvproceedings": true, "reference": true, "mvreference": true,
+}
+
+// An entry of a .bib file. Fields holds the values with their macros
+// expanded and their parts concatenated, but still in LaTeX; field names
+// are lower case. Err is set when the entry could not be read completely.
+type bibEntry struct {
+	Type   string
+	Key    string
+	Fields map[string]string
+	Line   int
+	Err    error
+}
+
+// Reads the entries of a .bib file in the order they appear. @string
+// definitions are applied to the entries after them; @comment and
+// @preamble are skipped, and so is any text outside of entries, which
+// BibTeX treats as a comment. An entry with a syntax error is reported
+// with the error, and reading continues with the next line that starts
+// an entry.
+func parseBibTeX(src string) []bibEntry {
+	p := &bibParser{src: src, macros: map[string]string{}}
+	for i, month := range []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"} {
+		p.macros[month] = strconv.Itoa(i + 1)
+	}
+	var entries []bibEntry
+	for {
+		at := strings.IndexByte(p.src[p.pos:], '@')
+		if at < 0 {
+			return entries
+		}
+		start := p.pos + at
+		p.pos = start + 1
+		kind := strings.ToLower(p.name())
+		p.skipSpace()
+		if kind == "" || p.pos >= len(p.src) || (p.src[p.pos] != '{' && p.src[p.pos] != '(') {
+			// An @ in the text between entries, such as in an email address
+			continue
+		}
+		closing := byte('}')
+		if p.src[p.pos] == '(' {
+			closing = ')'
+		}
+		p.pos++
+
+		switch kind {
+		case "comment", "preamble":
+			if err := p.skipTo(closing); err != nil {
+				return entries
+			}
+		case "string":
+			if err := p.stringDefinition(closing); err != nil {
+				p.pos = start + 1
+				p.recover()
+			}
+		default:
+			entry := bibEntry{Type: kind, Fields: map[string]string{}, Line: 1 + strings.Count(p.src[:start], "\n")}
+			if entry.Err = p.entry(&entry, closing); entry.Err != nil {
+				// An unbalanced brace may have taken the rest of the file
+				// with it, so the search starts over after the entry's @
+				p.pos = start + 1
+				p.recover()
+			}
+			entries = append(entries, entry)
+		}
+	}
+}
+
+type bibParser struct {
+	src    string
+	pos    int
+	macros map[string]string
+}
+
+func (p *bibParser) skipSpace() {
+	for p.pos < len(p.src) && isBibSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func isBibSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// Reads an entry type, citation key part, field or macro name
+func (p *bibParser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if isBibSpace(c) || strings.IndexByte(`{}()",=#%@`, c) >= 0 {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// Moves past the delimiter that closes what was just opened, skipping
+// everything in between
+func (p *bibParser) skipTo(closing byte) error {
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == closing && depth == 0:
+			p.pos++
+			return nil
+		}
+	}
+	return fmt.Errorf("missing %q", closing)
+}
+
+// Continues after a syntax error with the next line that starts with an @
+func (p *bibParser) recover() {
+	for p.pos < len(p.src) {
+		next := strings.IndexByte(p.src[p.pos:], '\n')
+		if next < 0 {
+			p.pos = len(p.src)
+			return
+		}
+		p.pos += next + 1
+		if strings.HasPrefix(strings.TrimLeft(p.src[p.pos:], " \t"), "@") {
+			return
+		}
+	}
+}
+
+// @string{name = value}
+func (p *bibParser) stringDefinition(closing byte) error {
+	p.skipSpace()
+	name := strings.ToLower(p.name())
+	if name == "" {
+		return fmt.Errorf("expected a string name")
+	}
+	p.skipSpace()
+	if !p.consume('=') {
+		return fmt.Errorf("expected = after %s", name)
+	}
+	value, err := p.value(closing)
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if !p.consume(closing) {
+		return fmt.Errorf("expected %q after the string %s", closing, name)
+	}
+	p.macros[name] = value
+	return nil
+}
+
+func (p *bibParser) consume(c byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// The citation key and the fields of an entry up to its closing delimiter
+func (p *bibParser) entry(entry *bibEntry, closing byte) error {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ',' && p.src[p.pos] != closing && p.src[p.pos] != '\n' {
+		p.pos++
+	}
+	entry.Key = strings.TrimSpace(p.src[start:p.pos])
+	p.skipSpace()
+	if p.consume(closing) {
+		return nil
+	}
+	if !p.consume(',') {
+		return fmt.Errorf("expected , after the key %q", entry.Key)
+	}
+
+	for {
+		p.skipSpace()
+		if p.consume(closing) {
+			return nil
+		}
+		if p.pos >= len(p.src) {
+			return fmt.Errorf("missing %q at the end of the entry", closing)
+		}
+		field := strings.ToLower(p.name())
+		if field == "" {
+			return fmt.Errorf("expected a field name, found %q", p.src[p.pos])
+		}
+		p.skipSpace()
+		if !p.consume('=') {
+			return fmt.Errorf("expected = after %s", field)
+		}
+		value, err := p.value(closing)
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		// BibTeX uses the first of repeated fields
+		if _, ok := entry.Fields[field]; !ok {
+			entry.Fields[field] = value
+		}
+		p.skipSpace()
+		if !p.consume(',') && !(p.pos < len(p.src) && p.src[p.pos] == closing) {
+			return fmt.Errorf("expected , or %q after %s", closing, field)
+		}
+	}
+}
+
+// A field value: braced or quoted text, a number or a string name, or
+// several of them joined with #
+func (p *bibParser) value(closing byte) (string, error) {
+	var sb strings.Builder
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return "", fmt.Errorf("missing value")
+		}
+		switch c := p.src[p.pos]; {
+		case c == '{':
+			p.pos++
+			text, err := p.delimited('}')
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(text)
+		case c == '"':
+			p.pos++
+			text, err := p.delimited('"')
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(text)
+		case c >= '0' && c <= '9':
+			start := p.pos
+			for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+				p.pos++
+			}
+			sb.WriteString(p.src[start:p.pos])
+		default:
+			name := p.name()
+			if name == "" {
+				return "", fmt.Errorf("expected a value, found %q", c)
+			}
+			macro, ok := p.macros[strings.ToLower(name)]
+			if !ok {
+				return "", fmt.Errorf("undefined string %q", name)
+			}
+			sb.WriteString(macro)
+		}
+		p.skipSpace()
+		if !p.consume('#') {
+			return sb.String(), nil
+		}
+	}
+}
+
+// Text up to the end delimiter, which only counts outside of braces.
+// Braces inside are kept, they matter for names and capitalization.
+func (p *bibParser) delimited(end byte) (string, error) {
+	start, depth := p.pos, 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; {
+		case c == '\\':
+			// \{ and \} do not nest, and \" does not end a quoted value
+			p.pos++
+		case c == '}' && depth == 0 && end == '}':
+			text := p.src[start:p.pos]
+			p.pos++
+			return text, nil
+		case c == '"' && depth == 0 && end == '"':
+			text := p.src[start:p.pos]
+			p.pos++
+			return text, nil
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return "", fmt.Errorf("unbalanced }")
+			}
+			depth--
+		}
+	}
+	return "", fmt.Errorf("unterminated value")
+}
+
+// Combining marks of the LaTeX accent commands
+var latexAccents = map[string]rune{
+	`"`: '\u0308', `'`: '\u0301', "`": '\u0300', "^": '\u0302', "~": '\u0303',
+	"=": '\u0304', ".": '\u0307', "u": '\u0306', "v": '\u030C', "H": '\u030B',
+	"c": '\u0327', "k": '\u0328', "r": '\u030A', "d": '\u0323', "b": '\u0331',
+}
+
+// LaTeX commands that stand for a character or a word
+var latexSymbols = map[string]string{
+	"ss": "ß", "o": "ø", "O": "Ø", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"aa": "å", "AA": "Å", "l": "ł", "L": "Ł", "i": "i", "j": "j",
+	"dh": "ð", "DH": "Ð", "th": "þ", "TH": "Þ", "dj": "đ", "DJ": "Đ", "ng": "ŋ", "NG": "Ŋ",
+	"textendash": "–", "textemdash": "—", "textquoteleft": "‘", "textquoteright": "’",
+	"textquotedblleft": "“", "textquotedblright": "”", "guillemotleft": "«", "guillemotright": "»",
+	"S": "§", "P": "¶", "copyright": "©", "pounds": "£", "euro": "€",
+	"TeX": "TeX", "LaTeX": "LaTeX", "BibTeX": "BibTeX",
+}
+
+// Turns a BibTeX value into plain text: accents and special characters
+// become Unicode, braces and formatting commands such as \emph are dropped
+// but their text is kept, ties become spaces and dashes and quotes their
+// typographic forms. The result is NFC normalized with single spaces.
+func decodeLaTeX(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\':
+			out, n := latexCommand(s[i:])
+			sb.WriteString(out)
+			i += n
+		case c == '{' || c == '}' || c == '$':
+			i++
+		case c == '~':
+			sb.WriteByte(' ')
+			i++
+		case strings.HasPrefix(s[i:], "---"):
+			sb.WriteString("—")
+			i += 3
+		case strings.HasPrefix(s[i:], "--"):
+			sb.WriteString("–")
+			i += 2
+		case strings.HasPrefix(s[i:], "``"):
+			sb.WriteString("“")
+			i += 2
+		case strings.HasPrefix(s[i:], "''"):
+			sb.WriteString("”")
+			i += 2
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return strings.Join(strings.Fields(norm.NFC.String(sb.String())), " ")
+}
+
+// Decodes the command at the start of s, which starts with a backslash,
+// and returns its text and how many bytes it took
+func latexCommand(s string) (string, int) {
+	if len(s) < 2 {
+		return "", len(s)
+	}
+	n := 2
+	name := s[1:2]
+	if isASCIILetter(s[1]) {
+		for n < len(s) && isASCIILetter(s[n]) {
+			n++
+		}
+		name = s[1:n]
+	}
+	mark, accent := latexAccents[name]
+	if !accent && isASCIILetter(s[1]) {
+		// Spaces after a command word only end it
+		for n < len(s) && isBibSpace(s[n]) {
+			n++
+		}
+	}
+
+	switch {
+	case accent:
+		if isASCIILetter(s[1]) {
+			for n < len(s) && s[n] == ' ' {
+				n++
+			}
+		}
+		arg, taken := latexArgument(s[n:])
+		return accentFirst(decodeLaTeX(arg), mark), n + taken
+	case latexSymbols[name] != "":
+		return latexSymbols[name], n
+	case !isASCIILetter(s[1]):
+		switch s[1] {
+		case '\\':
+			return " ", n
+		case ' ', '&', '%', '$', '#', '_', '{', '}':
+			return s[1:2], n
+		}
+		// Hyphenation hints such as \- and spacing such as \,
+		return "", n
+	}
+	// Formatting such as \emph or \textbf: the braced text that follows is
+	// printed as it is
+	return "", n
+}
+
+// The argument of an accent: a braced group, a command such as \i, or the
+// next character
+func latexArgument(s string) (string, int) {
+	if s == "" {
+		return "", 0
+	}
+	switch s[0] {
+	case '{':
+		depth := 0
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					return s[1:i], i + 1
+				}
+			}
+		}
+		return s[1:], len(s)
+	case '\\':
+		_, n := latexCommand(s)
+		return s[:n], n
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size], size
+}
+
+// Puts the combining mark after the first character, where the accent goes
+func accentFirst(s string, mark rune) string {
+	if s == "" {
+		return ""
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size] + string(mark) + s[size:]
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Splits s at the separators that are not inside braces
+func splitBibTopLevel(s string, separator func(s string, i int) int) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		default:
+			if depth == 0 {
+				if n := separator(s, i); n > 0 {
+					parts = append(parts, s[start:i])
+					start = i + n
+					i += n - 1
+				}
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// " and " between two names, in any case and with any whitespace
+func nameSeparator(s string, i int) int {
+	if !isBibSpace(s[i]) || i+4 > len(s) || !strings.EqualFold(s[i+1:i+4], "and") {
+		return 0
+	}
+	j := i + 4
+	if j >= len(s) || !isBibSpace(s[j]) {
+		return 0
+	}
+	for j < len(s) && isBibSpace(s[j]) {
+		j++
+	}
+	return j - i
+}
+
+func commaSeparator(s string, i int) int {
+	if s[i] == ',' {
+		return 1
+	}
+	return 0
+}
+
+func spaceSeparator(s string, i int) int {
+	if isBibSpace(s[i]) {
+		return 1
+	}
+	return 0
+}
+
+// Writes a BibTeX name list such as "Shelley, Mary and Percy Bysshe
+// Shelley" the way the catalog has authors: "Mary Shelley and Percy Bysshe
+// Shelley", with commas before the last "and" for more names. "and
+// others" becomes "et al.".
+func bibAuthors(value string) string {
+	var names []string
+	others := false
+	for _, raw := range splitBibTopLevel(strings.TrimSpace(value), nameSeparator) {
+		raw = strings.TrimSpace(raw)
+		if strings.EqualFold(raw, "others") {
+			others = true
+			continue
+		}
+		if name := bibName(raw); name != "" {
+			names = append(names, name)
+		}
+	}
+	switch {
+	case len(names) == 0:
+		return ""
+	case others:
+		return strings.Join(names, ", ") + " et al."
+	case len(names) == 1:
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// Reorders a single BibTeX name into "First von Last Jr". The forms are
+// "First von Last", "von Last, First" and "von Last, Jr, First", where the
+// von part is the words starting in lower case. A braced name such as
+// "{World Health Organization}" is kept as one word.
+func bibName(raw string) string {
+	var first, von, last, jr []string
+	parts := splitBibTopLevel(raw, commaSeparator)
+	words := func(s string) []string {
+		var out []string
+		for _, w := range splitBibTopLevel(strings.TrimSpace(s), spaceSeparator) {
+			if w != "" {
+				out = append(out, w)
+			}
+		}
+		return out
+	}
+	// The von part of "von Last": leading lower case words, but never the
+	// last word
+	splitVon := func(ws []string) ([]string, []string) {
+		i := 0
+		for i < len(ws)-1 && startsLowerCase(ws[i]) {
+			i++
+		}
+		return ws[:i], ws[i:]
+	}
+
+	switch len(parts) {
+	case 1:
+		ws := words(parts[0])
+		if len(ws) == 0 {
+			return ""
+		}
+		i := 0
+		for i < len(ws)-1 && !startsLowerCase(ws[i]) {
+			i++
+		}
+		if i == len(ws)-1 {
+			first, last = ws[:len(ws)-1], ws[len(ws)-1:]
+		} else {
+			first = ws[:i]
+			von, last = splitVon(ws[i:])
+		}
+	case 2:
+		von, last = splitVon(words(parts[0]))
+		first = words(parts[1])
+	default:
+		von, last = splitVon(words(parts[0]))
+		jr = words(parts[1])
+		first = words(strings.Join(parts[2:], ","))
+	}
+	all := append(append(append(first, von...), last...), jr...)
+	return decodeLaTeX(strings.Join(all, " "))
+}
+
+// Whether a name word starts in lower case, which makes it part of the von
+// part. Braced words never do.
+func startsLowerCase(word string) bool {
+	if strings.HasPrefix(word, "{") {
+		return false
+	}
+	for _, r := range decodeLaTeX(word) {
+		if unicode.IsLetter(r) {
+			return unicode.IsLower(r)
+		}
+	}
+	return false
+}
+
+// A year of four digits that is not part of a longer number
+var bibYearPattern = regexp.MustCompile(`(?:^|[^0-9])([0-9]{4})(?:[^0-9]|$)`)
+
+// Converts an entry to a book. The author falls back to the editor, as
+// for collections, and the year to the date of biblatex. An ISBN field
+// listing several ISBNs gives the first one; the page count is taken from
+// pagetotal, as pages is a range within something else.
+func bibBook(entry bibEntry) (Book, []problem) {
+	field := func(name string) string { return decodeLaTeX(entry.Fields[name]) }
+	book := Book{
+		Name:     field("title"),
+		Subtitle: field("subtitle"),
+		Edition:  field("edition"),
+		Author:   bibAuthors(entry.Fields["author"]),
+	}
+	if book.Author == "" {
+		book.Author = bibAuthors(entry.Fields["editor"])
+	}
+	var problems []problem
+	year := field("year")
+	if year == "" {
+		year = field("date")
+	}
+	if year != "" {
+		if m := bibYearPattern.FindStringSubmatch(year); m != nil {
+			book.Year, _ = strconv.Atoi(m[1])
+		} else {
+			problems = append(problems, newProblem("bibtex_bad_year", year))
+		}
+	}
+	isbns := strings.FieldsFunc(field("isbn"), func(r rune) bool { return r == ',' || r == ';' || unicode.IsSpace(r) })
+	for _, isbn := range isbns {
+		if normalizeISBN(isbn) != "" {
+			book.ISBN = isbn
+			break
+		}
+	}
+	if book.ISBN == "" && len(isbns) > 0 {
+		book.ISBN = isbns[0]
+	}
+	if pages, err := strconv.Atoi(field("pagetotal")); err == nil {
+		book.Pages = pages
+	}
+	return book, problems
+}
+
+// Plans the import of the entries like planImport does for JSON, one row
+// per entry with its citation key. Entries that could not be read fail,
+// entries that are not books are skipped; only the books that could be
+// converted go through planImport, so the others cannot be planned nor
+// hold the place of a later duplicate.
+func planBibTeXImport(ctx context.Context, coll *mongo.Collection, entries []bibEntry, isbns isbnConversion, lang string, strict bool) (importReport, map[int]BookStore) {
+	report := importReport{Rows: make([]importRow, len(entries))}
+	var books []Book
+	// Row index of each of books
+	var rows []int
+	for i, entry := range entries {
+		row := importRow{Index: i, Key: entry.Key}
+		switch {
+		case entry.Err != nil:
+			row.Status = importFailed
+			row.Errors = []string{newProblem("bibtex_syntax", entry.Line, entry.Err.Error()).message(lang)}
+		case !bibBookTypes[entry.Type]:
+			row.Status = importSkipped
+			row.Errors = []string{newProblem("bibtex_not_a_book", entry.Type).message(lang)}
+		default:
+			book, problems := bibBook(entry)
+			if len(problems) == 0 {
+				books = append(books, book)
+				rows = append(rows, i)
+				continue
+			}
+			// Reported together with what else is wrong with the book
+			invalid, _ := checkBook(book, strict)
+			row.ISBN = normalizeISBN(book.ISBN)
+			row.Status = importFailed
+			row.Errors = messages(append(problems, invalid...), lang)
+		}
+		report.Rows[i] = row
+	}
+
+	booksReport, booksPlanned := planImport(ctx, coll, books, isbns, lang, strict)
+	planned := map[int]BookStore{}
+	for j, i := range rows {
+		row := booksReport.Rows[j]
+		row.Index, row.Key = i, entries[i].Key
+		report.Rows[i] = row
+		if book, ok := booksPlanned[j]; ok {
+			planned[i] = book
+		}
+	}
+	report.count()
+	return report, planned
+}
+
+// Files exported by older tools are often Latin-1 rather than UTF-8
+func bibText(data []byte) string {
+	if utf8.Valid(data) {
+		return strings.TrimPrefix(string(data), "\uFEFF")
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
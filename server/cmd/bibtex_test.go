@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBibTeX(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// Fields of the entries by citation key; nil for an entry that
+		// could not be read
+		want map[string]map[string]string
+		keys []string
+	}{
+		{
+			"nested braces",
+			`@book{knuth, title = {The {\TeX}book {of {nested}} braces}}`,
+			map[string]map[string]string{"knuth": {"title": `The {\TeX}book {of {nested}} braces`}},
+			[]string{"knuth"},
+		},
+		{
+			"concatenation",
+			`@book{k, title = "The " # {Art} # " of Computer Programming", year = 19 # 68}`,
+			map[string]map[string]string{"k": {"title": "The Art of Computer Programming", "year": "1968"}},
+			[]string{"k"},
+		},
+		{
+			"string macros",
+			"@string{aw = {Addison-Wesley}}\n@STRING(place = \"Reading\")\n@book{k, publisher = AW # {, } # Place, month = mar}",
+			map[string]map[string]string{"k": {"publisher": "Addison-Wesley, Reading", "month": "3"}},
+			[]string{"k"},
+		},
+		{
+			"escaped quote in a quoted value",
+			`@book{k, author = "M\"uller, Hans", title = "Say {"}Hi{"}"}`,
+			map[string]map[string]string{"k": {"author": `M\"uller, Hans`, "title": `Say {"}Hi{"}`}},
+			[]string{"k"},
+		},
+		{
+			"parentheses, upper case and the first of repeated fields",
+			`@BOOK(k, TITLE = {First}, title = {Second},)`,
+			map[string]map[string]string{"k": {"title": "First"}},
+			[]string{"k"},
+		},
+		{
+			"comments, preamble and text between entries",
+			"Written by someone@example.org\n@comment{ignore {this}}\n@preamble{\"\\newcommand{\\x}{y}\"}\n% @book{not, title={this}}\n@book{k, title = {Kept}}",
+			map[string]map[string]string{"not": {"title": "this"}, "k": {"title": "Kept"}},
+			[]string{"not", "k"},
+		},
+		{
+			"recovery after an unbalanced entry",
+			"@book{broken, title = {Never {closed}\n  year = 1999\n}\n@book{next, title = {Read}}\n",
+			map[string]map[string]string{"broken": nil, "next": {"title": "Read"}},
+			[]string{"broken", "next"},
+		},
+		{
+			"recovery after an undefined string",
+			"@book{undefined, publisher = nowhere}\n@book{next, title = {Read}}",
+			map[string]map[string]string{"undefined": nil, "next": {"title": "Read"}},
+			[]string{"undefined", "next"},
+		},
+		{
+			"missing comma",
+			"@book{k, title = {x} year = 1999}\n@book{next, title = {Read}}",
+			map[string]map[string]string{"k": nil, "next": {"title": "Read"}},
+			[]string{"k", "next"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries := parseBibTeX(test.src)
+			var keys []string
+			for _, entry := range entries {
+				keys = append(keys, entry.Key)
+				want := test.want[entry.Key]
+				if want == nil {
+					if entry.Err == nil {
+						t.Errorf("%s: no error, fields %v", entry.Key, entry.Fields)
+					}
+					continue
+				}
+				if entry.Err != nil {
+					t.Errorf("%s: %v", entry.Key, entry.Err)
+				} else if !reflect.DeepEqual(entry.Fields, want) {
+					t.Errorf("%s: got  %q\nwant %q", entry.Key, entry.Fields, want)
+				}
+			}
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("keys %q, want %q", keys, test.keys)
+			}
+		})
+	}
+}
+
+func TestBibAuthors(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"Mary Shelley", "Mary Shelley"},
+		{"Shelley, Mary", "Mary Shelley"},
+		{"Shelley, Mary and Percy Bysshe Shelley", "Mary Shelley and Percy Bysshe Shelley"},
+		{"Preston, Douglas and Child, Lincoln and Pendergast, Aloysius", "Douglas Preston, Lincoln Child and Aloysius Pendergast"},
+		{"Ludwig van Beethoven", "Ludwig van Beethoven"},
+		{"de la Fontaine, Jean", "Jean de la Fontaine"},
+		{"van Beethoven, Jr, Ludwig", "Ludwig van Beethoven Jr"},
+		{"King, Jr., Martin Luther", "Martin Luther King Jr."},
+		{"Knuth, Donald E. and others", "Donald E. Knuth et al."},
+		{"{World Health Organization}", "World Health Organization"},
+		{"{Barnes and Noble}", "Barnes and Noble"},
+		{"G{\\\"o}del, Kurt", "Kurt Gödel"},
+		{"{\\v{C}}apek, Karel", "Karel Čapek"},
+		{"Erd\\H{o}s, Paul AND R{\\'e}nyi, Alfr{\\'e}d", "Paul Erdős and Alfréd Rényi"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := bibAuthors(test.value); got != test.want {
+			t.Errorf("bibAuthors(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestDecodeLaTeX(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`M\"uller`, "Müller"},
+		{`M{\"u}ller`, "Müller"},
+		{`Caf\'{e}`, "Café"},
+		{`Gar\c con`, "Garçon"},
+		{`Stra\ss{}e`, "Straße"},
+		{`\o re`, "øre"},
+		{`Sm\'\i th`, "Smíth"},
+		{`\v{S}koda`, "Škoda"},
+		{`\emph{Very} \textbf{bold}`, "Very bold"},
+		{`{The {TeX}book}`, "The TeXbook"},
+		{`1990--1995 --- a decade`, "1990–1995 — a decade"},
+		{"``Quoted''", "“Quoted”"},
+		{`Tom~\&~Jerry`, "Tom & Jerry"},
+		{"  spread\n  over   lines ", "spread over lines"},
+	}
+	for _, test := range tests {
+		if got := decodeLaTeX(test.value); got != test.want {
+			t.Errorf("decodeLaTeX(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestBibText(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"UTF-8", []byte("M\xc3\xbcller"), "Müller"},
+		{"byte order mark", []byte("\xef\xbb\xbf@book{k}"), "@book{k}"},
+		{"Latin-1", []byte("M\xfcller, Fran\xe7ois"), "Müller, François"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := bibText(test.data); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBibBook(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   map[string]string
+		want     Book
+		problems int
+	}{
+		{
+			"fields",
+			map[string]string{
+				"title": `The {\TeX}book`, "subtitle": "Computers {\\&} Typesetting", "edition": "2",
+				"author": "Knuth, Donald E.", "year": "1984", "isbn": "0-201-13447-0", "pagetotal": "483",
+			},
+			Book{Name: "The TeXbook", Subtitle: "Computers & Typesetting", Edition: "2", Author: "Donald E. Knuth", Year: 1984, ISBN: "0-201-13447-0", Pages: 483},
+			0,
+		},
+		{
+			"editor and biblatex date",
+			map[string]string{"title": "Collected", "editor": "Shelley, Mary", "date": "1831-10-31"},
+			Book{Name: "Collected", Author: "Mary Shelley", Year: 1831},
+			0,
+		},
+		{
+			"first of several ISBNs",
+			map[string]string{"title": "T", "isbn": "n/a; 978-0-306-40615-7, 0-306-40615-2"},
+			Book{Name: "T", ISBN: "978-0-306-40615-7"},
+			0,
+		},
+		{
+			"pages is a range",
+			map[string]string{"title": "T", "pages": "10--20"},
+			Book{Name: "T"},
+			0,
+		},
+		{
+			"year without four digits",
+			map[string]string{"title": "T", "year": "forthcoming"},
+			Book{Name: "T"},
+			1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, problems := bibBook(bibEntry{Type: "book", Key: "k", Fields: test.fields})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got  %+v\nwant %+v", got, test.want)
+			}
+			if len(problems) != test.problems {
+				t.Errorf("problems %v, want %d", problems, test.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Values accepted for the integer fields of a book. They are kept within
+// 32 bits so a book reads the same on every platform the server is built
+// for; whether a value makes sense for a book is up to validateBook.
+var bookIntegerFields = []struct {
+	name     string
+	min, max int64
+}{
+	{"pages", math.MinInt32, math.MaxInt32},
+	{"year", math.MinInt32, math.MaxInt32},
+}
+
+// Header clients set to "true" to have unknown fields rejected even when
+// STRICT_JSON is off
+const strictJSONHeader = "X-Strict-Json"
+
+// Reports whether unknown fields in the body of the request are an error
+func strictJSON(c echo.Context, always bool) bool {
+	return always || c.Request().Header.Get(strictJSONHeader) == "true"
+}
+
+// Binds a book, a patch or an array of them from the request body like
+// c.Bind does, after checking the integer fields. A fraction or a value
+// beyond 32 bits is returned as a problem naming the field and its range
+// and v is left alone, where binding would answer with the decoder's
+// message or, on 64-bit builds, take a value bookIntegerFields rules out.
+// Aliases of fields, see parseFieldAliases, and loosely typed values are
+// accepted as described at loosenFields. In strict mode the latter are
+// problems, and so is a field v does not have, usually a typo like
+// "titel", rather than being dropped. So is any other body the strict
+// decoder cannot take, trailing data after it included.
+func bindBook(c echo.Context, v interface{}, strict bool, aliases map[string]string) ([]problem, error) {
+	req := c.Request()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	body, invalid := loosenFields(body, strict, aliases)
+	if len(invalid) > 0 {
+		return invalid, nil
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if invalid := checkIntegers(body); len(invalid) > 0 {
+		return invalid, nil
+	}
+	if !strict {
+		return nil, c.Bind(v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return []problem{newProblem("field_unknown", field)}, nil
+		}
+		return []problem{newProblem("body_invalid", strings.TrimPrefix(err.Error(), "json: "))}, nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return []problem{newProblem("body_invalid", "data after the JSON value")}, nil
+	}
+	return nil, nil
+}
+
+// Answers a request whose body bindBook found problems in
+func respondBindingProblems(c echo.Context, invalid []problem) error {
+	switch invalid[0].Code {
+	case "field_unknown":
+		return respondProblem(c, 400, newProblem("unknown_fields"), invalid...)
+	case "body_invalid":
+		return respondProblem(c, 400, invalid[0])
+	}
+	return respondProblem(c, 400, newProblem("invalid_numbers"), invalid...)
+}
+
+// Answers a request whose body could not be bound at all, such as one with
+// true or [1] where a number belongs. Binding it anyway would leave those
+// fields at zero.
+func respondBindingError(c echo.Context, err error) error {
+	message := err.Error()
+	if he, ok := err.(*echo.HTTPError); ok {
+		message = fmt.Sprint(he.Message)
+	}
+	return respondProblem(c, 400, newProblem("body_invalid", message))
+}
+
+// Characters some clients leave around a pasted ISBN
+const isbnQuotes = "\"'\u201c\u201d\u2018\u2019`"
+
+// Parses FIELD_ALIASES: comma-separated alias=field pairs such as
+// "Title=name,ISBN13=isbn", the alternative names of the fields of a book
+// that legacy clients send. They are returned keyed by the alias in lower
+// case. Aliases are matched regardless of case, like the field names
+// themselves, and must not be the name of a field.
+func parseFieldAliases(value string) (map[string]string, error) {
+	aliases := map[string]string{}
+	if value == "" {
+		return aliases, nil
+	}
+	fields := bookFieldNames()
+	for _, pair := range strings.Split(value, ",") {
+		alias, field, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		alias, field = strings.TrimSpace(alias), strings.TrimSpace(field)
+		if !ok || alias == "" {
+			return nil, fmt.Errorf("FIELD_ALIASES: %q must look like Title=name", pair)
+		}
+		if !slices.Contains(fields, field) {
+			return nil, fmt.Errorf("FIELD_ALIASES: %q is no field of a book, expected one of %s", field, strings.Join(fields, ", "))
+		}
+		if slices.ContainsFunc(fields, func(f string) bool { return strings.EqualFold(f, alias) }) {
+			return nil, fmt.Errorf("FIELD_ALIASES: %q is a field of a book already", alias)
+		}
+		aliases[strings.ToLower(alias)] = field
+	}
+	return aliases, nil
+}
+
+// JSON names of the fields of Book
+func bookFieldNames() []string {
+	var names []string
+	t := reflect.TypeOf(Book{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Renames the keys of object that are aliases to the fields they stand
+// for. A field sent under its own name as well keeps that value.
+func resolveAliases(object map[string]interface{}, aliases map[string]string) bool {
+	changed := false
+	for key, value := range object {
+		field, ok := aliases[strings.ToLower(key)]
+		if !ok {
+			continue
+		}
+		delete(object, key)
+		if _, ok := object[field]; !ok {
+			object[field] = value
+		}
+		changed = true
+	}
+	return changed
+}
+
+// Takes what loosely typed clients send: keys in aliases are renamed
+// to the fields they stand for, integer fields written as strings such as
+// "1924" become numbers, and quotes and whitespace around the ISBN are
+// removed. A string that is no whole number is a problem, as is any string
+// in strict mode, where only the aliases are resolved. The body is only
+// encoded anew when something was changed.
+func loosenFields(body []byte, strict bool, aliases map[string]string) ([]byte, []problem) {
+	value, ok := decodeBody(body)
+	if !ok {
+		return body, nil
+	}
+	changed := false
+	var invalid []problem
+	forEachObject(value, func(object map[string]interface{}, prefix string) {
+		if resolveAliases(object, aliases) {
+			changed = true
+		}
+		for _, field := range bookIntegerFields {
+			for _, key := range fieldKeys(object, field.name) {
+				text, ok := object[key].(string)
+				if !ok {
+					continue
+				}
+				n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+				if err != nil || strict {
+					invalid = append(invalid, newProblem("integer_invalid", prefix+key, field.min, field.max, strconv.Quote(text)))
+					continue
+				}
+				// Written anew, as "+1924" and "01924" are no JSON numbers
+				object[key] = json.Number(strconv.FormatInt(n, 10))
+				changed = true
+			}
+		}
+		if isbn, ok := object["isbn"].(string); ok && !strict {
+			if trimmed := strings.TrimSpace(strings.Trim(strings.TrimSpace(isbn), isbnQuotes)); trimmed != isbn {
+				object["isbn"] = trimmed
+				changed = true
+			}
+		}
+	})
+	if len(invalid) > 0 || !changed {
+		return body, invalid
+	}
+	loosened, err := json.Marshal(value)
+	if err != nil {
+		return body, nil
+	}
+	return loosened, nil
+}
+
+// Checks the integer fields of a JSON object or of the objects in a JSON
+// array. Bodies that are no valid JSON are left to the binding to report.
+func checkIntegers(body []byte) []problem {
+	value, ok := decodeBody(body)
+	if !ok {
+		return nil
+	}
+	var invalid []problem
+	forEachObject(value, func(object map[string]interface{}, prefix string) {
+		invalid = append(invalid, checkIntegerFields(object, prefix)...)
+	})
+	return invalid
+}
+
+// Decodes a JSON body keeping numbers as they were written
+func decodeBody(body []byte) (interface{}, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Calls fn with a decoded JSON object, or with each object in a JSON array
+// together with the prefix of its fields in problems, such as "[2]."
+func forEachObject(value interface{}, fn func(object map[string]interface{}, prefix string)) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		fn(value, "")
+	case []interface{}:
+		for i, item := range value {
+			if object, ok := item.(map[string]interface{}); ok {
+				fn(object, fmt.Sprintf("[%d].", i))
+			}
+		}
+	}
+}
+
+func checkIntegerFields(object map[string]interface{}, prefix string) []problem {
+	var invalid []problem
+	for _, field := range bookIntegerFields {
+		for _, key := range fieldKeys(object, field.name) {
+			number, ok := object[key].(json.Number)
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseInt(number.String(), 10, 64)
+			if err != nil || n < field.min || n > field.max {
+				invalid = append(invalid, newProblem("integer_invalid", prefix+key, field.min, field.max, number.String()))
+			}
+		}
+	}
+	return invalid
+}
+
+// Keys of object that binding puts into the field name. encoding/json
+// matches them regardless of case, so "Pages" is checked like "pages".
+func fieldKeys(object map[string]interface{}, name string) []string {
+	var keys []string
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
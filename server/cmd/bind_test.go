@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Binds body into v the way the handlers do and returns the fields
+// reported as invalid, joined by commas
+func bindTestBody(t *testing.T, body string, v interface{}, strict bool) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/books", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	invalid, err := bindBook(echo.New().NewContext(req, httptest.NewRecorder()), v, strict, nil)
+	if err != nil {
+		t.Fatalf("binding %s: %v", body, err)
+	}
+	var fields []string
+	for _, p := range invalid {
+		fields = append(fields, p.Args[0].(string))
+	}
+	return strings.Join(fields, ",")
+}
+
+func TestBindBookIntegers(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		invalid string
+		pages   int
+		year    int
+	}{
+		{"integers", `{"pages": 292, "year": 1924}`, "", 292, 1924},
+		{"negative year", `{"year": -800}`, "", 0, -800},
+		{"float", `{"pages": 292.5}`, "pages", 0, 0},
+		{"whole float", `{"pages": 292.0}`, "pages", 0, 0},
+		{"exponent", `{"year": 1.924e3}`, "year", 0, 0},
+		{"largest 32-bit value", `{"pages": 2147483647}`, "", 2147483647, 0},
+		{"above 32 bits", `{"pages": 2147483648}`, "pages", 0, 0},
+		{"below 32 bits", `{"year": -2147483649}`, "year", 0, 0},
+		{"above 64 bits", `{"year": 99999999999999999999}`, "year", 0, 0},
+		{"both", `{"pages": 1e10, "year": 1924.5}`, "pages,year", 0, 0},
+		{"key in another case", `{"Pages": 3000000000}`, "Pages", 0, 0},
+		{"keys in several cases", `{"YEAR": 1924, "Year": 1e3}`, "Year", 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var book Book
+			if got := bindTestBody(t, test.body, &book, false); got != test.invalid {
+				t.Fatalf("invalid fields %q, want %q", got, test.invalid)
+			}
+			if book.Pages != test.pages || book.Year != test.year {
+				t.Errorf("bound pages %d and year %d, want %d and %d", book.Pages, book.Year, test.pages, test.year)
+			}
+		})
+	}
+
+	var books []Book
+	if got := bindTestBody(t, `[{"pages": 10}, {"pages": 10.5}]`, &books, false); got != "[1].pages" {
+		t.Errorf("invalid fields of a batch %q, want [1].pages", got)
+	}
+}
+
+func TestBindBookLooselyTyped(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		strict  bool
+		invalid string
+		year    int
+		isbn    string
+	}{
+		{"year as string", `{"year": "1924"}`, false, "", 1924, ""},
+		{"year with spaces", `{"year": " 1924 "}`, false, "", 1924, ""},
+		{"negative year as string", `{"year": "-800"}`, false, "", -800, ""},
+		{"signed year as string", `{"year": "+1924"}`, false, "", 1924, ""},
+		{"signed negative year with spaces", `{"year": " -800\t"}`, false, "", -800, ""},
+		{"year with tabs and newlines", `{"year": "\t1924\n"}`, false, "", 1924, ""},
+		{"year with leading zeros", `{"year": "01924"}`, false, "", 1924, ""},
+		{"year with a space after the sign", `{"year": "+ 1924"}`, false, "year", 0, ""},
+		{"year that is no number", `{"year": "abc"}`, false, "year", 0, ""},
+		{"empty year", `{"year": ""}`, false, "year", 0, ""},
+		{"fractional year as string", `{"year": "1924.5"}`, false, "year", 0, ""},
+		{"year as string beyond 32 bits", `{"year": "3000000000"}`, false, "year", 0, ""},
+		{"year as string in strict mode", `{"year": "1924"}`, true, "year", 0, ""},
+		{"year as string in another case", `{"Year": "1924"}`, false, "", 1924, ""},
+		{"year as string beyond 32 bits in another case", `{"YEAR": "3000000000"}`, false, "YEAR", 0, ""},
+		{"quoted ISBN", `{"isbn": "\"978-3-16-148410-0\" "}`, false, "", 0, "978-3-16-148410-0"},
+		{"curly quoted ISBN", `{"isbn": "“9783161484100”"}`, false, "", 0, "9783161484100"},
+		{"quoted ISBN in strict mode", `{"isbn": "'9783161484100'"}`, true, "", 0, "'9783161484100'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var book Book
+			if got := bindTestBody(t, test.body, &book, test.strict); got != test.invalid {
+				t.Fatalf("invalid fields %q, want %q", got, test.invalid)
+			}
+			if book.Year != test.year || book.ISBN != test.isbn {
+				t.Errorf("bound year %d and ISBN %q, want %d and %q", book.Year, book.ISBN, test.year, test.isbn)
+			}
+		})
+	}
+}
+
+// In strict mode every body the decoder cannot take is a problem, not an
+// error the handlers might drop
+func TestBindBookStrict(t *testing.T) {
+	tests := []struct {
+		name, body, code string
+	}{
+		{"valid", `{"name": "Dune", "year": 1965}`, ""},
+		{"unknown field", `{"titel": "Dune"}`, "field_unknown"},
+		{"wrong type", `{"name": "Dune", "year": true}`, "body_invalid"},
+		{"array for a number", `{"pages": [1]}`, "body_invalid"},
+		{"syntax error", `{"name": "Dune",}`, "body_invalid"},
+		{"truncated", `{"name": "Dune"`, "body_invalid"},
+		{"trailing data", `{"name": "Dune"} {"name": "Emma"}`, "body_invalid"},
+		{"trailing brace", `{"name": "Dune"}}`, "body_invalid"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/books", strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			var book Book
+			invalid, err := bindBook(echo.New().NewContext(req, httptest.NewRecorder()), &book, true, nil)
+			if err != nil {
+				t.Fatalf("got error %v, want a problem", err)
+			}
+			var code string
+			if len(invalid) > 0 {
+				code = invalid[0].Code
+			}
+			if code != test.code {
+				t.Errorf("got problem %q, want %q", code, test.code)
+			}
+		})
+	}
+}
+
+func TestBindBookAliases(t *testing.T) {
+	aliases, err := parseFieldAliases("Title=name, ISBN13=isbn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, body, bookName, isbn string
+	}{
+		{"aliases", `{"Title": "Dune", "ISBN13": "9780441013593"}`, "Dune", "9780441013593"},
+		{"any case", `{"title": "Dune"}`, "Dune", ""},
+		{"field wins over its alias", `{"name": "Dune", "Title": "Emma"}`, "Dune", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/books", strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			var book Book
+			invalid, err := bindBook(echo.New().NewContext(req, httptest.NewRecorder()), &book, false, aliases)
+			if err != nil || len(invalid) > 0 {
+				t.Fatalf("got %v and %v", invalid, err)
+			}
+			if book.Name != test.bookName || book.ISBN != test.isbn {
+				t.Errorf("bound name %q and ISBN %q, want %q and %q", book.Name, book.ISBN, test.bookName, test.isbn)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Outcomes of a single id of a bulk delete
+const (
+	bulkDeleted   = "deleted"
+	bulkNotFound  = "not-found"
+	bulkInvalidID = "invalid-id"
+	bulkLocked    = "locked"
+)
+
+// What happened to one of the ids of a bulk delete
+type bulkDeleteResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Deletes the books with the given ids with a single DeleteMany and reports
+// the outcome of every id, in the order they were given and each one once.
+// Books somebody other than owner holds the edit lock of are kept, as with
+// a single delete. Returns the results and the number of books the
+// DeleteMany removed.
+func bulkDeleteBooks(ctx context.Context, coll, hist *mongo.Collection, webhooks *webhookNotifier, user, owner string, ids []string) ([]bulkDeleteResult, int64, error) {
+	results := make([]bulkDeleteResult, 0, len(ids))
+	var objectIDs []primitive.ObjectID
+	seen := map[string]bool{}
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			if !seen[id] {
+				seen[id] = true
+				results = append(results, bulkDeleteResult{ID: id, Status: bulkInvalidID})
+			}
+			continue
+		}
+		// Ids are compared in their canonical form, the hex digits may
+		// have been sent in upper case
+		if seen[objectID.Hex()] {
+			continue
+		}
+		seen[objectID.Hex()] = true
+		objectIDs = append(objectIDs, objectID)
+		results = append(results, bulkDeleteResult{ID: objectID.Hex(), Status: bulkNotFound})
+	}
+	if len(objectIDs) == 0 {
+		return results, 0, nil
+	}
+
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}}, findTimeLimit(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	var found []BookStore
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, 0, err
+	}
+	deletable := map[string]BookStore{}
+	status := map[string]string{}
+	var deleteIDs []primitive.ObjectID
+	for _, book := range found {
+		if lockedByOther(book, owner) {
+			status[book.ID.Hex()] = bulkLocked
+			continue
+		}
+		deletable[book.ID.Hex()] = book
+		deleteIDs = append(deleteIDs, book.ID)
+	}
+	var deleted int64
+	if len(deleteIDs) > 0 {
+		// The lock is checked again as the books are deleted, somebody may
+		// have taken it since they were read
+		filter := bson.M{"_id": bson.M{"$in": deleteIDs}, "$and": bson.A{unlockedFilter(owner, time.Now().UTC())}}
+		res, err := coll.DeleteMany(ctx, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		deleted = res.DeletedCount
+	}
+	// Fewer deleted than planned: the books still there were locked
+	// meanwhile, the others were deleted by a request at the same time
+	if deleted < int64(len(deleteIDs)) {
+		cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": deleteIDs}},
+			findTimeLimit(ctx).SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, 0, err
+		}
+		var remaining []BookStore
+		if err = cursor.All(ctx, &remaining); err != nil {
+			return nil, 0, err
+		}
+		for _, book := range remaining {
+			delete(deletable, book.ID.Hex())
+			status[book.ID.Hex()] = bulkLocked
+		}
+	}
+	// Which of the vanished books the other request deleted cannot be told,
+	// so none of them gets a tombstone rather than some getting two
+	record := int64(len(deletable)) == deleted
+	if !record {
+		logger.WarnContext(ctx, "books of a bulk delete were deleted concurrently, history left to the other request",
+			"planned", len(deleteIDs), "deleted", deleted)
+	}
+
+	for i, result := range results {
+		if result.Status == bulkInvalidID {
+			continue
+		}
+		if s, ok := status[result.ID]; ok {
+			results[i].Status = s
+		} else if book, ok := deletable[result.ID]; ok {
+			results[i].Status = bulkDeleted
+			if record {
+				recordAudit(ctx, hist, webhooks, actionDeleted, user, &book, nil)
+			}
+		}
+	}
+	return results, deleted, nil
+}
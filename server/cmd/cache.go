@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// How long the answers of the route groups may be cached when CACHE_MAX_AGE
+// does not say otherwise. The aggregations change only as fast as the
+// catalog grows; any other read route is revalidated on every use.
+var defaultCacheMaxAge = map[string]time.Duration{
+	"/api/stats":       5 * time.Minute,
+	"/api/authors/top": 5 * time.Minute,
+	"/api/years":       5 * time.Minute,
+}
+
+// Parses the CACHE_MAX_AGE setting, e.g. "/api/stats=1h,/api/books=30s",
+// on top of defaultCacheMaxAge. A route prefix covers the routes below it
+// too; zero means caches have to revalidate every time.
+func parseCacheMaxAge(value string) (map[string]time.Duration, error) {
+	ages := map[string]time.Duration{}
+	for prefix, age := range defaultCacheMaxAge {
+		ages[prefix] = age
+	}
+	if value == "" {
+		return ages, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		prefix, age, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.HasPrefix(prefix, "/api/") {
+			return nil, fmt.Errorf("CACHE_MAX_AGE: %q must look like /api/stats=5m", pair)
+		}
+		d, err := time.ParseDuration(age)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("CACHE_MAX_AGE: the age for %s must be a duration such as 5m", prefix)
+		}
+		ages[strings.TrimSuffix(prefix, "/")] = d
+	}
+	return ages, nil
+}
+
+// Cache-Control of a successful GET of the route. The longest matching
+// prefix decides. The admin routes are never stored, they answer only with
+// the token and may list data an anonymous reader must not see.
+func cacheControl(path string, ages map[string]time.Duration) string {
+	if path == "/api/admin" || strings.HasPrefix(path, "/api/admin/") {
+		return "no-store"
+	}
+	match, age := "", time.Duration(0)
+	for prefix, d := range ages {
+		if (path == prefix || strings.HasPrefix(path, prefix+"/")) && len(prefix) > len(match) {
+			match, age = prefix, d
+		}
+	}
+	if age <= 0 {
+		return "no-cache"
+	}
+	return fmt.Sprintf("max-age=%d", int(age/time.Second))
+}
+
+// Adds Cache-Control and an ETag to the answers of the GET routes below
+// basePath/api and answers 304 Not Modified when the client already holds
+// the current version. The tag is a hash of the body, so the body is
+// buffered up to maxETagBytes. Larger bodies, downloads and streams that
+// flush go out untagged instead.
+func cacheMiddleware(basePath string, ages map[string]time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Method != http.MethodGet || !strings.HasPrefix(c.Path(), basePath+"/api/") {
+				return next(c)
+			}
+
+			res := c.Response()
+			// The envelope and profile of an answer follow the Accept header
+			res.Header().Add(echo.HeaderVary, "Accept")
+			w := &etagWriter{
+				ResponseWriter: res.Writer,
+				cacheControl:   cacheControl(strings.TrimPrefix(c.Path(), basePath), ages),
+				ifNoneMatch:    req.Header.Get("If-None-Match"),
+			}
+			res.Writer = w
+			defer func() {
+				if w.finish() {
+					res.Status = http.StatusNotModified
+				}
+				res.Writer = w.ResponseWriter
+			}()
+			// An error has to be rendered before finish, or its body would
+			// miss the buffer and its status the no-store
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+			return nil
+		}
+	}
+}
+
+// Largest body an ETag is computed for. A longer one, such as a big page of
+// books, is sent as it comes rather than held in memory whole.
+const maxETagBytes = 1 << 20
+
+// Response writer that holds the body back until the handler is done, so
+// the ETag can be computed from it
+type etagWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	ifNoneMatch  string
+
+	code   int
+	buf    bytes.Buffer
+	passed bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	// Downloads such as the CSV export stream the whole catalog, which
+	// must not pile up here
+	if w.Header().Get(echo.HeaderContentDisposition) != "" {
+		w.pass()
+	}
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passed && w.buf.Len()+len(p) > maxETagBytes {
+		w.pass()
+	}
+	if w.passed {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Gives up on the tag: sends the status with what was held back so far and
+// lets the rest of the body through as it is written
+func (w *etagWriter) pass() {
+	w.passed = true
+	w.setCacheControl()
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+}
+
+// Sets Cache-Control unless the handler chose one itself. Failures are not
+// stored at all, they should not outlive the problem.
+func (w *etagWriter) setCacheControl() {
+	header := w.Header()
+	if header.Get(echo.HeaderCacheControl) != "" {
+		return
+	}
+	if w.code >= 200 && w.code < 300 {
+		header.Set(echo.HeaderCacheControl, w.cacheControl)
+	} else {
+		header.Set(echo.HeaderCacheControl, "no-store")
+	}
+}
+
+// Sends the buffered response, or only 304 when the client's copy is
+// current, which the result reports
+func (w *etagWriter) finish() bool {
+	if w.passed || w.code == 0 {
+		return false
+	}
+	w.setCacheControl()
+	header := w.Header()
+	if w.code == http.StatusOK && header.Get("ETag") == "" {
+		sum := sha256.Sum256(w.buf.Bytes())
+		// Weak, as the bytes differ with the content encoding
+		header.Set("ETag", `W/"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	if w.code == http.StatusOK && etagMatches(w.ifNoneMatch, header.Get("ETag")) {
+		header.Del(echo.HeaderContentType)
+		header.Del(echo.HeaderContentLength)
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(w.buf.Bytes())
+	return false
+}
+
+// A flush asks for the data to go out now, which rules out a tag of the
+// whole body, so the response passes through from here on
+func (w *etagWriter) Flush() {
+	if !w.passed {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		w.pass()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Weak comparison of an If-None-Match header against the current tag, as
+// RFC 9110 asks for GET
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Small answers are tagged and revalidated; downloads and bodies beyond
+// maxETagBytes pass through untagged instead of being held in memory
+func TestCacheMiddlewareETag(t *testing.T) {
+	e := echo.New()
+	e.Use(cacheMiddleware("", nil))
+	e.GET("/api/books", func(c echo.Context) error {
+		return c.String(200, "books")
+	})
+	e.GET("/api/books/large", func(c echo.Context) error {
+		return c.String(200, strings.Repeat("x", maxETagBytes+1))
+	})
+	e.GET("/api/books/export.csv", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="books.csv"`)
+		c.Response().WriteHeader(200)
+		_, err := c.Response().Write([]byte("name\n"))
+		return err
+	})
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/books", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != 200 || rec.Body.String() != "books" || etag == "" {
+		t.Fatalf("got %d %q with ETag %q", rec.Code, rec.Body, etag)
+	}
+	if rec = get("/api/books", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation got %d %q, want 304", rec.Code, rec.Body)
+	}
+	for _, path := range []string{"/api/books/large", "/api/books/export.csv"} {
+		rec = get(path, "")
+		if rec.Code != 200 || rec.Header().Get("ETag") != "" || rec.Header().Get(echo.HeaderCacheControl) == "" {
+			t.Errorf("%s got %d with ETag %q and Cache-Control %q, want 200 untagged", path, rec.Code,
+				rec.Header().Get("ETag"), rec.Header().Get(echo.HeaderCacheControl))
+		}
+	}
+	if rec.Body.String() != "name\n" {
+		t.Errorf("download body %q", rec.Body)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Citation styles of /api/books/:id/citation. Chicago is the bibliography
+// entry of the notes and bibliography system.
+const (
+	citationAPA     = "apa"
+	citationMLA     = "mla"
+	citationChicago = "chicago"
+)
+
+// A citation as sent to clients that ask for JSON
+type bookCitation struct {
+	Style    string `json:"style"`
+	Citation string `json:"citation"`
+}
+
+func validCitationStyle(style string) bool {
+	switch style {
+	case citationAPA, citationMLA, citationChicago:
+		return true
+	}
+	return false
+}
+
+// Formats book as a reference in the given style. The catalog knows no
+// publisher, so the citations end with the year. Titles are taken as they
+// are written, without changing their case, and italics are left to the
+// client as the citation is plain text.
+func citeBook(book BookStore, style string) string {
+	title := book.BookName
+	if book.BookSubtitle != "" {
+		title += ": " + book.BookSubtitle
+	}
+	author := citationAuthors(book.BookAuthor, style)
+	edition := citationEdition(book.BookEdition)
+
+	var parts []string
+	switch style {
+	case citationAPA:
+		// Shelley, M. W. (1818). Frankenstein (3rd ed.).
+		year := "n.d."
+		if book.BookYear != 0 {
+			year = strconv.Itoa(book.BookYear)
+		}
+		if author != "" {
+			parts = append(parts, terminate(author))
+		}
+		parts = append(parts, "("+year+").")
+		if edition != "" {
+			title += " (" + edition + ")"
+		}
+		parts = append(parts, terminate(title))
+	case citationMLA:
+		// Shelley, Mary Wollstonecraft. Frankenstein. 3rd ed., 1818.
+		if author != "" {
+			parts = append(parts, terminate(author))
+		}
+		parts = append(parts, terminate(title))
+		var details []string
+		if edition != "" {
+			details = append(details, edition)
+		}
+		if book.BookYear != 0 {
+			details = append(details, strconv.Itoa(book.BookYear))
+		}
+		if len(details) > 0 {
+			parts = append(parts, terminate(strings.Join(details, ", ")))
+		}
+	default:
+		// Shelley, Mary Wollstonecraft. Frankenstein. 3rd ed. 1818.
+		if author != "" {
+			parts = append(parts, terminate(author))
+		}
+		parts = append(parts, terminate(title))
+		if edition != "" {
+			parts = append(parts, terminate(edition))
+		}
+		year := "n.d."
+		if book.BookYear != 0 {
+			year = strconv.Itoa(book.BookYear)
+		}
+		parts = append(parts, terminate(year))
+	}
+	return strings.Join(parts, " ")
+}
+
+// The authors of a book as the style lists them. APA inverts every name
+// and joins the last with "&"; MLA and Chicago invert only the first and
+// join with "and", and MLA writes "et al." after the first of three or
+// more.
+func citationAuthors(author, style string) string {
+	names, etAl := splitAuthors(author)
+	people := make([]string, 0, len(names))
+	for i, name := range names {
+		person := parsePersonName(name)
+		switch {
+		case style == citationAPA:
+			people = append(people, apaAuthor(person))
+		case i == 0:
+			people = append(people, person.format(conventionLastFirst))
+		default:
+			people = append(people, person.format(conventionFirstLast))
+		}
+	}
+	if style == citationMLA && len(people) > 2 {
+		people, etAl = people[:1], true
+	}
+	switch {
+	case len(people) == 0:
+		return ""
+	case etAl:
+		return strings.Join(people, ", ") + ", et al."
+	case len(people) == 1:
+		return people[0]
+	}
+	last := "and "
+	if style == citationAPA {
+		last = "& "
+	}
+	head := strings.Join(people[:len(people)-1], ", ")
+	if len(people) == 2 && style != citationAPA && !strings.Contains(people[0], ",") {
+		// "Homer and Virgil" needs no comma
+		return head + " " + last + people[1]
+	}
+	return head + ", " + last + people[len(people)-1]
+}
+
+// "Shelley, M. W.": the family name followed by the initials of the given
+// names
+func apaAuthor(author personName) string {
+	var initials []string
+	for _, name := range strings.FieldsFunc(author.Given, func(r rune) bool { return r == ' ' || r == '.' }) {
+		initials = append(initials, string([]rune(name)[0])+".")
+	}
+	author.Given = strings.Join(initials, " ")
+	return author.format(conventionLastFirst)
+}
+
+// Writes a numeric edition such as "3" as "3rd ed."; editions written out
+// ("Second revised ed.") are kept as they are. The first edition is not
+// mentioned in a citation.
+func citationEdition(edition string) string {
+	n, err := strconv.Atoi(strings.TrimSpace(edition))
+	if err != nil {
+		return strings.TrimSpace(edition)
+	}
+	if n <= 1 {
+		return ""
+	}
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s ed.", n, suffix)
+}
+
+// Ends a part of a citation with a period unless it already ends with a
+// punctuation mark, as in "Jr." or a title ending in "?"
+func terminate(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return s
+	}
+	if r := []rune(s)[len([]rune(s))-1]; unicode.IsPunct(r) && r != ')' && r != '"' {
+		return s
+	}
+	return s + "."
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestCiteBook(t *testing.T) {
+	frankenstein := BookStore{BookName: "Frankenstein", BookAuthor: "Mary Wollstonecraft Shelley", BookYear: 1818, BookEdition: "3"}
+	tests := []struct {
+		name  string
+		book  BookStore
+		style string
+		want  string
+	}{
+		{"APA", frankenstein, citationAPA, "Shelley, M. W. (1818). Frankenstein (3rd ed.)."},
+		{"MLA", frankenstein, citationMLA, "Shelley, Mary Wollstonecraft. Frankenstein. 3rd ed., 1818."},
+		{"Chicago", frankenstein, citationChicago, "Shelley, Mary Wollstonecraft. Frankenstein. 3rd ed. 1818."},
+
+		{"APA without year", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley"}, citationAPA, "Shelley, M. (n.d.). Frankenstein."},
+		{"MLA without year", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley"}, citationMLA, "Shelley, Mary. Frankenstein."},
+		{"Chicago without year", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley"}, citationChicago, "Shelley, Mary. Frankenstein. n.d."},
+
+		{"APA without edition", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookYear: 1818}, citationAPA, "Shelley, M. (1818). Frankenstein."},
+		{"MLA without edition", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookYear: 1818}, citationMLA, "Shelley, Mary. Frankenstein. 1818."},
+		{"first edition", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookYear: 1818, BookEdition: "1"}, citationChicago, "Shelley, Mary. Frankenstein. 1818."},
+		{"written out edition", BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookYear: 1831, BookEdition: "Revised ed."}, citationMLA, "Shelley, Mary. Frankenstein. Revised ed., 1831."},
+
+		{"APA with two authors", BookStore{BookName: "Relic", BookAuthor: "Douglas Preston and Lincoln Child", BookYear: 1995}, citationAPA, "Preston, D., & Child, L. (1995). Relic."},
+		{"MLA with two authors", BookStore{BookName: "Relic", BookAuthor: "Douglas Preston and Lincoln Child", BookYear: 1995}, citationMLA, "Preston, Douglas, and Lincoln Child. Relic. 1995."},
+		{"Chicago with two authors", BookStore{BookName: "Relic", BookAuthor: "Douglas Preston & Lincoln Child", BookYear: 1995}, citationChicago, "Preston, Douglas, and Lincoln Child. Relic. 1995."},
+		{
+			"APA with three authors",
+			BookStore{BookName: "Tales", BookAuthor: "Mary Shelley, Percy Bysshe Shelley and Lord Byron", BookYear: 1816},
+			citationAPA, "Shelley, M., Shelley, P. B., & Byron, L. (1816). Tales.",
+		},
+		{
+			"MLA with three authors",
+			BookStore{BookName: "Tales", BookAuthor: "Mary Shelley, Percy Bysshe Shelley and Lord Byron", BookYear: 1816},
+			citationMLA, "Shelley, Mary, et al. Tales. 1816.",
+		},
+		{
+			"Chicago with three authors",
+			BookStore{BookName: "Tales", BookAuthor: "Mary Shelley, Percy Bysshe Shelley and Lord Byron", BookYear: 1816},
+			citationChicago, "Shelley, Mary, Percy Bysshe Shelley, and Lord Byron. Tales. 1816.",
+		},
+		{"APA et al.", BookStore{BookName: "Tales", BookAuthor: "Mary Shelley et al.", BookYear: 1816}, citationAPA, "Shelley, M., et al. (1816). Tales."},
+
+		{"subtitle", BookStore{BookName: "Frankenstein", BookSubtitle: "The Modern Prometheus", BookAuthor: "Mary Shelley", BookYear: 1818}, citationMLA, "Shelley, Mary. Frankenstein: The Modern Prometheus. 1818."},
+		{"suffix", BookStore{BookName: "Why We Can't Wait", BookAuthor: "Martin Luther King Jr.", BookYear: 1964}, citationAPA, "King, M. L., Jr. (1964). Why We Can't Wait."},
+		{"title ending in a question mark", BookStore{BookName: "What Is to Be Done?", BookAuthor: "Vladimir Lenin", BookYear: 1902}, citationMLA, "Lenin, Vladimir. What Is to Be Done? 1902."},
+		{"single name", BookStore{BookName: "The Odyssey", BookAuthor: "Homer"}, citationChicago, "Homer. The Odyssey. n.d."},
+		{"no author", BookStore{BookName: "Beowulf"}, citationAPA, "(n.d.). Beowulf."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := citeBook(test.book, test.style); got != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCitationEdition(t *testing.T) {
+	for edition, want := range map[string]string{
+		"":            "",
+		"1":           "",
+		"2":           "2nd ed.",
+		"3":           "3rd ed.",
+		"4":           "4th ed.",
+		"11":          "11th ed.",
+		"12":          "12th ed.",
+		"21":          "21st ed.",
+		" 22 ":        "22nd ed.",
+		"Revised ed.": "Revised ed.",
+	} {
+		if got := citationEdition(edition); got != want {
+			t.Errorf("citationEdition(%q) = %q, want %q", edition, got, want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Largest number of groups a duplicate report lists
+const maxDuplicateGroups = 500
+
+// A set of books that look like the same record
+type duplicateGroup struct {
+	// What the books have in common: "title-author" or "isbn"
+	Kind string `json:"kind"`
+	// The normalized value they share
+	Key   string `json:"key"`
+	Books []Book `json:"books"`
+}
+
+// Normalized "title|author" of a document: trimmed and lower case
+var titleAuthorExpr = bson.M{"$concat": bson.A{
+	bson.M{"$toLower": bson.M{"$trim": bson.M{"input": bson.M{"$ifNull": bson.A{"$bookname", ""}}}}},
+	"|",
+	bson.M{"$toLower": bson.M{"$trim": bson.M{"input": bson.M{"$ifNull": bson.A{"$bookauthor", ""}}}}},
+}}
+
+// Finds books that are probably the same record stored more than once: the
+// same title and author apart from case and surrounding whitespace, or the
+// same ISBN written with or without hyphens. These slip past the duplicate
+// check, as do exact copies from POST /api/books?skipDuplicateCheck=true
+// and from imports that ran at the same time.
+func findDuplicateGroups(ctx context.Context, coll *mongo.Collection) ([]duplicateGroup, error) {
+	groups := []duplicateGroup{}
+	for _, kind := range []struct {
+		name string
+		key  interface{}
+	}{
+		{"title-author", titleAuthorExpr},
+		{"isbn", normalizedISBNExpr},
+	} {
+		found, err := groupByKey(ctx, coll, kind.key, maxDuplicateGroups-len(groups))
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range found {
+			group.Kind = kind.name
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// Groups the documents by the given expression and returns the groups with
+// more than one member, largest first. Empty keys, e.g. books without an
+// ISBN, never form a group.
+func groupByKey(ctx context.Context, coll *mongo.Collection, key interface{}, limit int) ([]duplicateGroup, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   key,
+			"books": bson.M{"$push": "$$ROOT"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}, "_id": bson.M{"$nin": bson.A{"", "|"}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Key   string      `bson:"_id"`
+		Books []BookStore `bson:"books"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	groups := make([]duplicateGroup, 0, len(results))
+	for _, res := range results {
+		group := duplicateGroup{Key: res.Key, Books: make([]Book, 0, len(res.Books))}
+		for _, book := range res.Books {
+			group.Books = append(group.Books, convertToBook(book))
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// ISBN that more than one book is stored with
+type isbnConflict struct {
+	// The normalized ISBN the books share
+	ISBN  string `json:"isbn"`
+	Books []Book `json:"books"`
+}
+
+// Outcome of checking whether a unique index on the ISBN could be created
+type isbnUniquenessReport struct {
+	Unique    bool           `json:"unique"`
+	Checked   int            `json:"checked"`
+	Conflicts []isbnConflict `json:"conflicts"`
+}
+
+// Lists the ISBNs that are shared by more than one book, regardless of
+// their formatting and edition, so they can be cleaned up before a unique
+// index is created. The database groups the books by their normalized
+// ISBN the way findDuplicateGroups does, so only the conflicts are read.
+// Nothing is modified.
+func checkISBNUniqueness(ctx context.Context, coll *mongo.Collection) (isbnUniquenessReport, error) {
+	report := isbnUniquenessReport{Conflicts: []isbnConflict{}}
+	checked, err := countBooks(ctx, coll, bson.M{"bookisbn": bson.M{"$nin": bson.A{nil, ""}}})
+	if err != nil {
+		return report, err
+	}
+	report.Checked = int(checked)
+	groups, err := groupByKey(ctx, coll, normalizedISBNExpr, maxDuplicateGroups)
+	if err != nil {
+		return report, err
+	}
+	for _, group := range groups {
+		report.Conflicts = append(report.Conflicts, isbnConflict{ISBN: group.Key, Books: group.Books})
+	}
+	report.Unique = len(report.Conflicts) == 0
+	return report, nil
+}
+
+// Documents fetched per round trip while scanning for invalid ISBNs
+const isbnScanBatchSize = 500
+
+// A stored ISBN that fails isValidISBN, as it is stored
+type invalidISBN struct {
+	ID   string `json:"id"`
+	ISBN string `json:"isbn"`
+}
+
+// Outcome of scanning the ISBNs. Truncated is set when there were more
+// invalid ISBNs than the report holds.
+type invalidISBNReport struct {
+	Checked   int           `json:"checked"`
+	Invalid   []invalidISBN `json:"invalid"`
+	Truncated bool          `json:"truncated"`
+}
+
+// Lists the books whose ISBN fails the check digit or format test, in the
+// order they were added. The database cannot do the arithmetic, so every
+// ISBN is read once, in batches and without the rest of the book; at most
+// limit of them are kept.
+func findInvalidISBNs(ctx context.Context, coll *mongo.Collection, limit int) (invalidISBNReport, error) {
+	report := invalidISBNReport{Invalid: []invalidISBN{}}
+	opts := options.Find().
+		SetProjection(bson.M{"bookisbn": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetBatchSize(isbnScanBatchSize)
+	cursor, err := coll.Find(ctx, bson.M{"bookisbn": bson.M{"$nin": bson.A{nil, ""}}}, opts, findTimeLimit(ctx))
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var book BookStore
+		if err := cursor.Decode(&book); err != nil {
+			return report, err
+		}
+		report.Checked++
+		if isValidISBN(book.BookISBN) {
+			continue
+		}
+		if len(report.Invalid) == limit {
+			report.Truncated = true
+			continue
+		}
+		report.Invalid = append(report.Invalid, invalidISBN{ID: book.ID.Hex(), ISBN: book.BookISBN})
+	}
+	return report, cursor.Err()
+}
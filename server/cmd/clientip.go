@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Parses TRUSTED_PROXIES: a comma-separated list of addresses or CIDR
+// ranges, e.g. "10.0.0.0/8, 192.168.1.7".
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", entry)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// Decides where c.RealIP() takes the client address from. Without trusted
+// proxies it is the address of the connection, as forwarding headers can be
+// set by anybody. Otherwise X-Forwarded-For is followed back through the
+// trusted proxies, and only through them, to the first address that is not
+// one of them.
+func clientIPExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range trusted {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+// Content encodings the server can produce, keyed by their Accept-Encoding
+// token
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"br": func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	},
+	"gzip": func(w io.Writer) io.WriteCloser {
+		gz, _ := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+		return gz
+	},
+}
+
+// Parses the COMPRESSION setting: a comma-separated list of encodings in
+// the order the server prefers them. Empty, the default, or "none" leaves
+// the responses uncompressed.
+func parseCompression(value string) ([]string, error) {
+	if value == "" || value == "none" {
+		return nil, nil
+	}
+	var algorithms []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := encoders[name]; !ok {
+			return nil, fmt.Errorf("COMPRESSION: unknown encoding %q", name)
+		}
+		algorithms = append(algorithms, name)
+	}
+	return algorithms, nil
+}
+
+// Picks the encoding for a response out of the client's Accept-Encoding
+// header. The client's weights decide; on equal weights the order of
+// algorithms does. An empty result means the body is sent as it is.
+func negotiateEncoding(header string, algorithms []string) string {
+	weights := map[string]float64{}
+	wildcard := 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		if name == "*" {
+			wildcard = weight
+		} else {
+			weights[name] = weight
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, algorithm := range algorithms {
+		weight, ok := weights[algorithm]
+		if !ok {
+			weight = wildcard
+		}
+		if weight > bestWeight {
+			best, bestWeight = algorithm, weight
+		}
+	}
+	return best
+}
+
+// Compresses responses with the best encoding both sides support. Bodies
+// smaller than minBytes are not worth it and are sent as they are, which is
+// why the start of every body is buffered until the decision can be made.
+func compressMiddleware(algorithms []string, minBytes int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := c.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			encoding := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding), algorithms)
+			if encoding == "" || c.Request().Method == http.MethodHead {
+				return next(c)
+			}
+
+			w := &compressWriter{
+				ResponseWriter: res.Writer,
+				encoding:       encoding,
+				newEncoder:     encoders[encoding],
+				minBytes:       minBytes,
+			}
+			res.Writer = w
+			defer func() {
+				w.finish()
+				res.Writer = w.ResponseWriter
+			}()
+			// Errors are rendered here so their body goes through the
+			// writer as well
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+			return nil
+		}
+	}
+}
+
+// Response writer that holds the body back until minBytes are written and
+// then either starts compressing or passes everything through unchanged.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	newEncoder func(io.Writer) io.WriteCloser
+	minBytes   int
+
+	code    int
+	buf     []byte
+	encoder io.WriteCloser
+	plain   bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	switch {
+	case w.encoder != nil:
+		return w.encoder.Write(p)
+	case w.plain:
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minBytes {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Called once enough of the body is known
+func (w *compressWriter) start() error {
+	header := w.Header()
+	if header.Get(echo.HeaderContentEncoding) != "" || !compressible(header.Get(echo.HeaderContentType)) ||
+		w.code == http.StatusNoContent || w.code == http.StatusNotModified {
+		return w.sendPlain()
+	}
+	header.Set(echo.HeaderContentEncoding, w.encoding)
+	header.Del(echo.HeaderContentLength)
+	w.ResponseWriter.WriteHeader(w.code)
+	w.encoder = w.newEncoder(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.encoder.Write(buf)
+	return err
+}
+
+func (w *compressWriter) sendPlain() error {
+	w.plain = true
+	w.ResponseWriter.WriteHeader(w.code)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Streaming handlers flush to get data out right away. Whatever was held
+// back so far is sent as it is and the rest of the body follows unchanged.
+func (w *compressWriter) Flush() {
+	if w.encoder == nil && !w.plain {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		w.sendPlain()
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Sends what is still buffered once the handler is done
+func (w *compressWriter) finish() {
+	switch {
+	case w.encoder != nil:
+		w.encoder.Close()
+	case !w.plain && w.code != 0:
+		w.sendPlain()
+	}
+}
+
+// Images and archives are compressed already
+func compressible(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "image/svg"):
+		return true
+	case strings.HasPrefix(contentType, "image/"),
+		strings.HasPrefix(contentType, "video/"),
+		strings.HasPrefix(contentType, "audio/"),
+		strings.HasPrefix(contentType, "application/zip"),
+		strings.HasPrefix(contentType, "application/gzip"):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	both := []string{"br", "gzip"}
+	tests := []struct {
+		header     string
+		algorithms []string
+		want       string
+	}{
+		{"", both, ""},
+		{"gzip", both, "gzip"},
+		{"gzip, br", both, "br"},
+		{"gzip, br", []string{"gzip", "br"}, "gzip"},
+		{"GZIP", both, "gzip"},
+		{"x-gzip", both, "gzip"},
+		{"deflate", both, ""},
+		{"br;q=0.5, gzip;q=0.8", both, "gzip"},
+		{"br; q=0.9, gzip", both, "gzip"},
+		{"br;q=1.0, gzip;q=1", both, "br"},
+		{"br;q=0, gzip", both, "gzip"},
+		{"br;q=0, gzip;q=0", both, ""},
+		{"identity", both, ""},
+		{"*", both, "br"},
+		{"*;q=0.5, gzip", both, "gzip"},
+		{"*, br;q=0", both, "gzip"},
+		{"*;q=0", both, ""},
+		{"*;q=0, gzip", both, "gzip"},
+		{"br;q=high, gzip;q=0.1", both, "gzip"},
+		{" , gzip ,", both, "gzip"},
+		{"gzip, br", nil, ""},
+		{"br", []string{"gzip"}, ""},
+	}
+	for _, test := range tests {
+		if got := negotiateEncoding(test.header, test.algorithms); got != test.want {
+			t.Errorf("negotiateEncoding(%q, %v) = %q, want %q", test.header, test.algorithms, got, test.want)
+		}
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	const minBytes = 64
+	large := strings.Repeat("Frankenstein; or, The Modern Prometheus. ", 20)
+	e := echo.New()
+	e.Use(compressMiddleware([]string{"br", "gzip"}, minBytes))
+	e.GET("/small", func(c echo.Context) error {
+		return c.String(200, "short")
+	})
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/large", func(c echo.Context) error {
+		return c.String(200, large)
+	})
+	e.GET("/exact", func(c echo.Context) error {
+		return c.String(200, strings.Repeat("x", minBytes))
+	})
+	e.GET("/image", func(c echo.Context) error {
+		return c.Blob(200, "image/png", []byte(large))
+	})
+	e.GET("/created", func(c echo.Context) error {
+		return c.String(http.StatusCreated, large)
+	})
+	e.GET("/empty", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/error", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot, large)
+	})
+	// Streams flush before enough is known; the body is sent as it is
+	e.GET("/stream", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlain)
+		c.Response().Write([]byte("first "))
+		c.Response().Flush()
+		c.Response().Write([]byte(large))
+		return nil
+	})
+	// Flushing once compression has started flushes the encoder
+	e.GET("/stream-late", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlain)
+		c.Response().Write([]byte(large))
+		c.Response().Flush()
+		c.Response().Write([]byte("last"))
+		return nil
+	})
+
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var r io.Reader = rec.Body
+		switch rec.Header().Get(echo.HeaderContentEncoding) {
+		case "gzip":
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		case "br":
+			r = brotli.NewReader(rec.Body)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	tests := []struct {
+		name           string
+		method, path   string
+		acceptEncoding string
+		code           int
+		encoding       string
+		body           string
+	}{
+		{"below the threshold", http.MethodGet, "/small", "gzip", 200, "", "short"},
+		{"at the threshold", http.MethodGet, "/exact", "gzip", 200, "gzip", strings.Repeat("x", minBytes)},
+		{"gzip", http.MethodGet, "/large", "gzip", 200, "gzip", large},
+		{"brotli", http.MethodGet, "/large", "gzip, br", 200, "br", large},
+		{"not accepted", http.MethodGet, "/large", "deflate", 200, "", large},
+		{"no Accept-Encoding", http.MethodGet, "/large", "", 200, "", large},
+		// The recorder keeps what a server would drop from the answer
+		{"HEAD", http.MethodHead, "/large", "gzip", 200, "", large},
+		{"compressed already", http.MethodGet, "/image", "gzip", 200, "", large},
+		{"status kept", http.MethodGet, "/created", "gzip", http.StatusCreated, "gzip", large},
+		{"no content", http.MethodGet, "/empty", "gzip", http.StatusNoContent, "", ""},
+		{"error", http.MethodGet, "/error", "gzip", http.StatusTeapot, "gzip", `{"message":"` + large + `"}` + "\n"},
+		{"flush before the threshold", http.MethodGet, "/stream", "gzip", 200, "", "first " + large},
+		{"flush after the threshold", http.MethodGet, "/stream-late", "gzip", 200, "gzip", large + "last"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set(echo.HeaderAcceptEncoding, test.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != test.code {
+				t.Errorf("status %d, want %d", rec.Code, test.code)
+			}
+			if got := rec.Header().Get(echo.HeaderContentEncoding); got != test.encoding {
+				t.Errorf("Content-Encoding %q, want %q", got, test.encoding)
+			}
+			if got := rec.Header().Get(echo.HeaderVary); got != echo.HeaderAcceptEncoding {
+				t.Errorf("Vary %q, want %s", got, echo.HeaderAcceptEncoding)
+			}
+			if test.encoding != "" && rec.Header().Get(echo.HeaderContentLength) != "" {
+				t.Errorf("Content-Length %s of the uncompressed body sent", rec.Header().Get(echo.HeaderContentLength))
+			}
+			if got := decode(t, rec); got != test.body {
+				t.Errorf("body %q, want %q", got, test.body)
+			}
+		})
+	}
+}
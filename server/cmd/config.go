@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Runtime settings of the server. Everything is read once from the
+// environment at startup so the rest of the code never touches os.Getenv.
+type config struct {
+	// Connection string of the MongoDB instance (DATABASE_URI), or the one
+	// assembled from DB_HOST and the other DB_ variables without it
+	DatabaseURI string
+	// Serve the API only, without the webpages and their views
+	// (DISABLE_HTML)
+	DisableHTML bool
+	// Log request and response bodies of the /api routes (DEBUG_BODIES)
+	DebugBodies bool
+	// Maximum number of bytes of a body that is logged (DEBUG_BODIES_MAX)
+	DebugBodiesMax int
+	// Bearer token that grants admin privileges (ADMIN_TOKEN)
+	AdminToken string
+	// Keys accepted in X-API-Key for changes, none to leave them open
+	// (API_KEYS)
+	APIKeys []string
+	// Directory uploaded cover images are stored in (COVER_DIR)
+	CoverDir string
+	// Maximum size of an uploaded cover image in bytes (COVER_MAX_BYTES)
+	CoverMaxBytes int64
+	// Alternative names of the fields of a book, keyed by the alias in
+	// lower case (FIELD_ALIASES)
+	FieldAliases map[string]string
+	// Store valid ISBN-10s as their ISBN-13 (CONVERT_ISBN10)
+	ConvertISBN10 bool
+	// Keep the ISBN-10 a converted book was sent with (KEEP_ORIGINAL_ISBN)
+	KeepOriginalISBN bool
+	// Reject books with fields the server does not know (STRICT_JSON)
+	StrictJSON bool
+	// Wrap every /api response into an envelope (RESPONSE_ENVELOPE)
+	ResponseEnvelope bool
+	// Order of the book lists when the client sends no ?sort= (DEFAULT_SORT)
+	DefaultSort bson.D
+	// URL notified of every change of a book, none to notify nobody
+	// (WEBHOOK_URL)
+	WebhookURL string
+	// Key of the HMAC signature of the webhook requests (WEBHOOK_SECRET)
+	WebhookSecret string
+	// How long a single webhook request may take (WEBHOOK_TIMEOUT)
+	WebhookTimeout time.Duration
+	// How often a failed webhook request is repeated (WEBHOOK_RETRIES)
+	WebhookRetries int
+	// Members of the replica set reads go to, nil for the driver's choice
+	// (READ_PREFERENCE)
+	ReadPreference *readpref.ReadPref
+	// Acknowledgement writes wait for, nil for the driver's choice
+	// (WRITE_CONCERN)
+	WriteConcern *writeconcern.WriteConcern
+	// Report the health as unavailable while a migration is pending
+	// (REQUIRE_SCHEMA)
+	RequireSchema bool
+	// Longest the database may work on a single read (QUERY_MAX_TIME)
+	QueryMaxTime time.Duration
+	// Put around the words of the query in highlighted search results
+	// (HIGHLIGHT_PRE and HIGHLIGHT_POST)
+	HighlightPre  string
+	HighlightPost string
+	// Read the catalog once before accepting requests (WARMUP)
+	Warmup bool
+	// Indent JSON responses, for development (PRETTY_JSON)
+	PrettyJSON bool
+	// Casing of the keys in JSON responses (JSON_CASE)
+	JSONCase string
+	// Content encodings in order of preference (COMPRESSION)
+	Compression []string
+	// Responses smaller than this are sent uncompressed (COMPRESSION_MIN_BYTES)
+	CompressionMinBytes int
+	// Requests allowed to use the database at the same time, 0 for no limit
+	// (DB_MAX_CONCURRENT)
+	DBMaxConcurrent int
+	// How long a request waits for its turn before it gets a 503
+	// (DB_QUEUE_TIMEOUT)
+	DBQueueTimeout time.Duration
+	// Public reads a client address may send per minute, 0 for no limit
+	// (PUBLIC_RATE_LIMIT)
+	PublicRateLimit int
+	// Public reads a client may send at once on top of the limit
+	// (PUBLIC_RATE_BURST)
+	PublicRateBurst int
+	// Largest size of all request headers together (MAX_HEADER_BYTES)
+	MaxHeaderBytes int
+	// Longest request URI, path and query together (MAX_URI_LENGTH)
+	MaxURILength int
+	// How long a shutdown waits for running requests (SHUTDOWN_TIMEOUT)
+	ShutdownTimeout time.Duration
+	// Header carrying the request id, taken from the client when it sends one
+	// (REQUEST_ID_HEADER)
+	RequestIDHeader string
+	// How often the book count reported in /metrics is refreshed
+	// (COUNT_REFRESH_INTERVAL)
+	CountRefreshInterval time.Duration
+	// How long the authors and years for the filters are cached, 0 to read
+	// them on every request (DISTINCT_CACHE_TTL)
+	DistinctCacheTTL time.Duration
+	// How long a book stays locked for editing unless the lock is renewed
+	// (LOCK_TTL)
+	LockTTL time.Duration
+	// Proxies whose X-Forwarded-For header is believed (TRUSTED_PROXIES)
+	TrustedProxies []*net.IPNet
+	// Most books the catalog may hold, 0 for no limit (MAX_BOOKS)
+	MaxBooks int
+	// Most documents a single list request may return (MAX_RESULTS)
+	MaxResults int
+	// Page size of list requests without ?limit=, 0 for no pagination
+	// (PAGE_SIZE)
+	PageSize int
+	// Page sizes of single list routes that override PageSize (PAGE_SIZES)
+	PageSizes map[string]int
+	// How long the answers of route groups may be cached (CACHE_MAX_AGE)
+	CacheMaxAge map[string]time.Duration
+	// Path prefix all routes are mounted under, e.g. /library (BASE_PATH).
+	// Empty for the root.
+	BasePath string
+	// Scheme and host the server is reached at from outside, e.g.
+	// https://books.example.org, for links in documents such as the feed
+	// (PUBLIC_URL). Empty if not configured.
+	PublicURL string
+}
+
+func loadConfig() (config, error) {
+	var cfg config
+	var err error
+
+	cfg.DatabaseURI = getenv("DATABASE_URI")
+	if cfg.DatabaseURI == "" {
+		cfg.DatabaseURI, err = buildDatabaseURI(getenv("DB_HOST"), getenv("DB_PORT"),
+			getenv("DB_USER"), getenv("DB_PASSWORD"), getenv("DB_AUTH_SOURCE"))
+		if err != nil {
+			return cfg, err
+		}
+	}
+	cfg.AdminToken = getenv("ADMIN_TOKEN")
+	cfg.APIKeys = parseAPIKeys(getenv("API_KEYS"))
+	if cfg.ReadPreference, err = parseReadPreference(getenv("READ_PREFERENCE")); err != nil {
+		return cfg, err
+	}
+	if cfg.WriteConcern, err = parseWriteConcern(getenv("WRITE_CONCERN")); err != nil {
+		return cfg, err
+	}
+	if cfg.BasePath, err = parseBasePath(getenv("BASE_PATH")); err != nil {
+		return cfg, err
+	}
+	if cfg.PublicURL, err = parsePublicURL(getenv("PUBLIC_URL")); err != nil {
+		return cfg, err
+	}
+	if cfg.DisableHTML, err = envBool("DISABLE_HTML", false); err != nil {
+		return cfg, err
+	}
+	if cfg.DebugBodies, err = envBool("DEBUG_BODIES", false); err != nil {
+		return cfg, err
+	}
+	if cfg.DebugBodiesMax, err = envInt("DEBUG_BODIES_MAX", 2048); err != nil {
+		return cfg, err
+	}
+	cfg.RequestIDHeader = http.CanonicalHeaderKey(envString("REQUEST_ID_HEADER", echo.HeaderXRequestID))
+	cfg.CoverDir = envString("COVER_DIR", "covers")
+	coverMax, err := envInt("COVER_MAX_BYTES", 2<<20)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.CoverMaxBytes = int64(coverMax)
+	if cfg.FieldAliases, err = parseFieldAliases(getenv("FIELD_ALIASES")); err != nil {
+		return cfg, err
+	}
+	if cfg.ConvertISBN10, err = envBool("CONVERT_ISBN10", false); err != nil {
+		return cfg, err
+	}
+	if cfg.KeepOriginalISBN, err = envBool("KEEP_ORIGINAL_ISBN", true); err != nil {
+		return cfg, err
+	}
+	if cfg.StrictJSON, err = envBool("STRICT_JSON", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ResponseEnvelope, err = envBool("RESPONSE_ENVELOPE", false); err != nil {
+		return cfg, err
+	}
+	cfg.WebhookURL = getenv("WEBHOOK_URL")
+	cfg.WebhookSecret = getenv("WEBHOOK_SECRET")
+	if cfg.WebhookURL != "" && cfg.WebhookSecret == "" {
+		return cfg, fmt.Errorf("WEBHOOK_SECRET must be set along with WEBHOOK_URL")
+	}
+	if cfg.WebhookTimeout, err = envDuration("WEBHOOK_TIMEOUT", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.WebhookRetries, err = envInt("WEBHOOK_RETRIES", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.DefaultSort, err = parseSort(getenv("DEFAULT_SORT")); err != nil {
+		return cfg, fmt.Errorf("DEFAULT_SORT: %w", err)
+	}
+	if cfg.JSONCase, err = parseJSONCase(envString("JSON_CASE", caseCamel)); err != nil {
+		return cfg, err
+	}
+	if cfg.RequireSchema, err = envBool("REQUIRE_SCHEMA", false); err != nil {
+		return cfg, err
+	}
+	if cfg.QueryMaxTime, err = envDuration("QUERY_MAX_TIME", 0); err != nil {
+		return cfg, err
+	}
+	cfg.HighlightPre = envString("HIGHLIGHT_PRE", "<mark>")
+	cfg.HighlightPost = envString("HIGHLIGHT_POST", "</mark>")
+	if cfg.Warmup, err = envBool("WARMUP", false); err != nil {
+		return cfg, err
+	}
+	if cfg.PrettyJSON, err = envBool("PRETTY_JSON", false); err != nil {
+		return cfg, err
+	}
+	if cfg.Compression, err = parseCompression(envString("COMPRESSION", "")); err != nil {
+		return cfg, err
+	}
+	if cfg.CompressionMinBytes, err = envInt("COMPRESSION_MIN_BYTES", 1024); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxBooks, err = envInt("MAX_BOOKS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxResults, err = envInt("MAX_RESULTS", 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxResults == 0 {
+		return cfg, fmt.Errorf("MAX_RESULTS must be at least 1")
+	}
+	if cfg.PageSize, err = envInt("PAGE_SIZE", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.PageSize > cfg.MaxResults {
+		return cfg, fmt.Errorf("PAGE_SIZE must not be larger than MAX_RESULTS (%d)", cfg.MaxResults)
+	}
+	if cfg.PageSizes, err = parsePageSizes(getenv("PAGE_SIZES"), cfg.MaxResults); err != nil {
+		return cfg, err
+	}
+	if cfg.CacheMaxAge, err = parseCacheMaxAge(getenv("CACHE_MAX_AGE")); err != nil {
+		return cfg, err
+	}
+	if cfg.TrustedProxies, err = parseTrustedProxies(getenv("TRUSTED_PROXIES")); err != nil {
+		return cfg, err
+	}
+	if cfg.CountRefreshInterval, err = envDuration("COUNT_REFRESH_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.CountRefreshInterval == 0 {
+		return cfg, fmt.Errorf("COUNT_REFRESH_INTERVAL must be longer than 0s")
+	}
+	if cfg.DistinctCacheTTL, err = envDuration("DISTINCT_CACHE_TTL", time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.LockTTL, err = envDuration("LOCK_TTL", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.DBMaxConcurrent, err = envInt("DB_MAX_CONCURRENT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.DBQueueTimeout, err = envDuration("DB_QUEUE_TIMEOUT", 2*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.PublicRateLimit, err = envInt("PUBLIC_RATE_LIMIT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.PublicRateLimit < 0 {
+		return cfg, fmt.Errorf("PUBLIC_RATE_LIMIT must not be negative")
+	}
+	if cfg.PublicRateBurst, err = envInt("PUBLIC_RATE_BURST", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.PublicRateBurst < 1 {
+		return cfg, fmt.Errorf("PUBLIC_RATE_BURST must be at least 1")
+	}
+	if cfg.MaxHeaderBytes, err = envInt("MAX_HEADER_BYTES", 32<<10); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxHeaderBytes < 1<<10 {
+		return cfg, fmt.Errorf("MAX_HEADER_BYTES must be at least 1024")
+	}
+	if cfg.MaxURILength, err = envInt("MAX_URI_LENGTH", 8<<10); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxURILength < 256 {
+		return cfg, fmt.Errorf("MAX_URI_LENGTH must be at least 256")
+	}
+	if cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Normalizes BASE_PATH to either "" or "/segment[/segment...]" without a
+// trailing slash, so routes can simply be appended to it.
+func parseBasePath(value string) (string, error) {
+	value = strings.TrimRight(value, "/")
+	if value == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(value, "/") || strings.ContainsAny(value, "?#* ") {
+		return "", fmt.Errorf("BASE_PATH: %q must be a path such as /library", value)
+	}
+	return value, nil
+}
+
+// Checks PUBLIC_URL and drops its trailing slash. BASE_PATH is added to it
+// separately, so it carries no path of its own.
+func parsePublicURL(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(strings.TrimRight(value, "/"))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("PUBLIC_URL: %q must be a URL such as https://books.example.org", value)
+	}
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
+
+// Reads a string variable, falling back to def when it is not set.
+func envString(name string, def string) string {
+	if value := getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Reads a boolean variable, falling back to def when it is not set.
+func envBool(name string, def bool) (bool, error) {
+	value := getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return def, fmt.Errorf("%s: %q is not a boolean", name, value)
+	}
+	return parsed, nil
+}
+
+// Reads a non-negative integer variable, falling back to def when it is not
+// set.
+func envInt(name string, def int) (int, error) {
+	value := getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return def, fmt.Errorf("%s: %q is not a non-negative integer", name, value)
+	}
+	return parsed, nil
+}
+
+// Reads a duration such as "15s" or "2m", falling back to def when it is
+// not set.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	value := getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return def, fmt.Errorf("%s: %q is not a duration such as 10s", name, value)
+	}
+	return parsed, nil
+}
+
+// Names of the variables loadConfig has read, filled in by getenv
+var knownSettings = map[string]bool{}
+
+// os.Getenv for loadConfig, which remembers the name so misspelled
+// variables can be pointed out
+func getenv(name string) string {
+	knownSettings[name] = true
+	return os.Getenv(name)
+}
+
+// Settings that are never written to the log
+var secretSettings = map[string]bool{
+	"AdminToken":    true,
+	"APIKeys":       true,
+	"WebhookSecret": true,
+}
+
+// Logs the effective configuration, so it is clear what a container
+// actually started with. Secrets only show whether they are set, and the
+// password in the database URI is masked. Variables in the environment
+// that are almost the name of a setting are warned about: a typo would
+// otherwise silently leave the setting at its default.
+func logConfig(cfg config, attrs ...any) {
+	v := reflect.ValueOf(cfg)
+	settings := make([]any, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name, value := v.Type().Field(i).Name, v.Field(i).Interface()
+		switch {
+		case secretSettings[name]:
+			value = redactedSetting(v.Field(i))
+		case name == "DatabaseURI":
+			value = redactURI(cfg.DatabaseURI)
+		case name == "WebhookURL" && cfg.WebhookURL != "":
+			// Hooks of chat services carry their token in the path
+			if parsed, err := url.Parse(cfg.WebhookURL); err == nil {
+				value = parsed.Scheme + "://" + parsed.Host + "/[redacted]"
+			}
+		}
+		settings = append(settings, slog.Any(name, loggableSetting(value)))
+	}
+	logger.Info("starting", append(attrs, slog.Group("config", settings...))...)
+
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if suggestion := similarSetting(name); suggestion != "" {
+			logger.Warn("unknown setting in the environment, it is ignored", "variable", name, "did_you_mean", suggestion)
+		}
+	}
+}
+
+func redactedSetting(v reflect.Value) string {
+	if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func redactURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "[unparsable]"
+	}
+	return parsed.Redacted()
+}
+
+// Renders the types the JSON log handler would show poorly, such as
+// durations in nanoseconds
+func loggableSetting(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case map[string]time.Duration:
+		out := map[string]string{}
+		for k, d := range v {
+			out[k] = d.String()
+		}
+		return out
+	case []*net.IPNet:
+		out := make([]string, 0, len(v))
+		for _, n := range v {
+			out = append(out, n.String())
+		}
+		return out
+	case bson.D:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			out = append(out, fmt.Sprintf("%s:%v", e.Key, e.Value))
+		}
+		return out
+	case *readpref.ReadPref:
+		if v == nil {
+			return "default"
+		}
+		return v.String()
+	case *writeconcern.WriteConcern:
+		if v == nil {
+			return "default"
+		}
+		return fmt.Sprintf("w=%v", v.W)
+	}
+	return value
+}
+
+// Returns the setting that name is most likely meant to be when it is not
+// one itself but differs from one by at most two letters
+func similarSetting(name string) string {
+	if knownSettings[name] || len(name) < 6 {
+		return ""
+	}
+	for known := range knownSettings {
+		if editDistance(name, known) <= 2 {
+			return known
+		}
+	}
+	return ""
+}
+
+// Levenshtein distance of two names
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
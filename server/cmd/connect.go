@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Checks the connection string before it is handed to the driver, so a typo
+// is reported as such instead of surfacing as an obscure driver error.
+func validateDatabaseURI(uri string) error {
+	if uri == "" {
+		return errors.New("DATABASE_URI is not set, e.g. DATABASE_URI=mongodb://localhost:27017, nor is DB_HOST")
+	}
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		return errors.New("DATABASE_URI must start with mongodb:// or mongodb+srv://")
+	}
+	if err := options.Client().ApplyURI(uri).Validate(); err != nil {
+		return fmt.Errorf("DATABASE_URI is malformed: %w", err)
+	}
+	return nil
+}
+
+// Assembles the connection string from DB_HOST, DB_PORT, DB_USER,
+// DB_PASSWORD and DB_AUTH_SOURCE, for environments that hand them out
+// separately. The credentials are escaped, so passwords may contain any
+// character. Returns "" without a host, leaving DATABASE_URI to be
+// reported as missing.
+func buildDatabaseURI(host, port, user, password, authSource string) (string, error) {
+	if host == "" {
+		if user != "" || password != "" || port != "" {
+			return "", errors.New("DB_USER, DB_PASSWORD and DB_PORT need DB_HOST")
+		}
+		return "", nil
+	}
+	if port == "" {
+		port = "27017"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("DB_PORT: %q is no port number", port)
+	}
+	uri := url.URL{Scheme: "mongodb", Host: net.JoinHostPort(host, port), Path: "/"}
+	switch {
+	case user != "" && password != "":
+		uri.User = url.UserPassword(user, password)
+	case user != "":
+		uri.User = url.User(user)
+	case password != "":
+		return "", errors.New("DB_PASSWORD needs DB_USER")
+	}
+	if authSource != "" {
+		uri.RawQuery = url.Values{"authSource": {authSource}}.Encode()
+	}
+	return uri.String(), nil
+}
+
+// Parses READ_PREFERENCE, one of the MongoDB read preference modes such as
+// primary or secondaryPreferred. Nil, for an empty value, leaves the
+// choice to the connection string and the driver.
+func parseReadPreference(value string) (*readpref.ReadPref, error) {
+	if value == "" {
+		return nil, nil
+	}
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("READ_PREFERENCE: %q must be primary, primaryPreferred, secondary, secondaryPreferred or nearest", value)
+	}
+	return readpref.New(mode)
+}
+
+// Parses WRITE_CONCERN: majority, or the number of members that must
+// acknowledge a write. Nil, for an empty value, leaves the choice to the
+// connection string and the driver.
+func parseWriteConcern(value string) (*writeconcern.WriteConcern, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if value == "majority" {
+		return writeconcern.Majority(), nil
+	}
+	w, err := strconv.Atoi(value)
+	if err != nil || w < 0 {
+		return nil, fmt.Errorf("WRITE_CONCERN: %q must be majority or a number of members such as 1", value)
+	}
+	return &writeconcern.WriteConcern{W: w}, nil
+}
+
+// Turns a failed connection attempt into a message that says what most
+// likely went wrong and where to look. The driver reports most problems as a
+// server selection error whose text carries the underlying cause, so the
+// text is inspected after the typed checks.
+func describeConnectError(err error) string {
+	msg := err.Error()
+
+	var dnsErr *net.DNSError
+	var cmdErr mongo.CommandError
+	switch {
+	case errors.As(err, &dnsErr) || strings.Contains(msg, "no such host"):
+		return "DNS lookup failed: check the host name in DATABASE_URI (" + msg + ")"
+	case errors.As(err, &cmdErr) && cmdErr.Code == 18,
+		strings.Contains(msg, "AuthenticationFailed"),
+		strings.Contains(msg, "auth error"):
+		return "authentication failed: check the user name, password and authSource in DATABASE_URI (" + msg + ")"
+	case strings.Contains(msg, "connection refused"):
+		return "connection refused: nothing is listening on the given host and port, is MongoDB running? (" + msg + ")"
+	case mongo.IsTimeout(err), errors.Is(err, context.DeadlineExceeded),
+		strings.Contains(msg, "server selection timeout"):
+		return "timed out: the server did not answer in time, check the host, port and any firewall in between (" + msg + ")"
+	}
+	return "could not connect to MongoDB: " + msg
+}
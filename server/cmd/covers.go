@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Route under which the stored cover images are served
+const coverURLPrefix = "/covers"
+
+var (
+	errUnsupportedCover = errors.New("unsupported cover image type")
+	errCoverTooLarge    = errors.New("cover image too large")
+)
+
+// File extensions of the accepted cover types, keyed by the sniffed type
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Stores the uploaded image as <dir>/<id>.<ext> and points the book's cover
+// at <urlPrefix>/<id>.<ext>. The type is sniffed from the content rather
+// than trusted from the request. Returns the updated book.
+func storeCover(ctx context.Context, coll *mongo.Collection, dir string, urlPrefix string, maxBytes int64, id primitive.ObjectID, header *multipart.FileHeader) (BookStore, error) {
+	if _, err := findBookByID(ctx, coll, id); err != nil {
+		return BookStore{}, err
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		return BookStore{}, err
+	}
+	defer src.Close()
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(src, sniff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return BookStore{}, err
+	}
+	ext, ok := coverExtensions[http.DetectContentType(sniff[:n])]
+	if !ok {
+		return BookStore{}, errUnsupportedCover
+	}
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return BookStore{}, err
+	}
+	name := id.Hex() + ext
+	// The upload goes to a file of its own first, so a failed or oversized
+	// one leaves the current cover in place
+	tmp, err := os.CreateTemp(dir, "."+id.Hex()+"-*"+ext)
+	if err != nil {
+		return BookStore{}, err
+	}
+	// The declared size was checked by the caller, but the multipart header
+	// can lie, so the copy itself is bounded as well.
+	written, err := io.Copy(tmp, io.LimitReader(io.MultiReader(bytes.NewReader(sniff[:n]), src), maxBytes+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxBytes {
+		err = errCoverTooLarge
+	}
+	if err == nil {
+		// CreateTemp makes the file readable for the owner only
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return BookStore{}, err
+	}
+	// A previous cover of the other type would otherwise linger around
+	for _, other := range coverExtensions {
+		if other != ext {
+			os.Remove(filepath.Join(dir, id.Hex()+other))
+		}
+	}
+
+	url := urlPrefix + "/" + name
+	if _, err = coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"bookcover": url}}); err != nil {
+		return BookStore{}, err
+	}
+	return findBookByID(ctx, coll, id)
+}
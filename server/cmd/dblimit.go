@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Bounds the number of requests working on the database at the same time,
+// so a burst queues up here for a while instead of exhausting the
+// connection pool of the driver. Handlers use the collection directly, so
+// a request holds its slot from start to end.
+type dbLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func newDBLimiter(max int, wait time.Duration) *dbLimiter {
+	return &dbLimiter{slots: make(chan struct{}, max), wait: wait}
+}
+
+// Lets at most max requests through at once. The others wait up to the
+// configured time for a slot and are answered with 503 after that. Routes
+// starting with one of skip, which do not use the database, are not
+// limited.
+func (l *dbLimiter) middleware(skip ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, prefix := range skip {
+				if strings.HasPrefix(c.Path(), prefix) {
+					return next(c)
+				}
+			}
+			timer := time.NewTimer(l.wait)
+			defer timer.Stop()
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+				return next(c)
+			case <-timer.C:
+				logger.WarnContext(c.Request().Context(), "database busy, request rejected",
+					"route", c.Path(), "waited", l.wait.String())
+				c.Response().Header().Set("Retry-After", "1")
+				return respondProblem(c, 503, newProblem("database_busy"))
+			case <-c.Request().Context().Done():
+				// The client gave up waiting, nobody reads the answer
+				return c.Request().Context().Err()
+			}
+		}
+	}
+}
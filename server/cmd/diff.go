@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Largest snapshot POST /api/books/diff compares with the catalog
+const maxDiffBooks = 5000
+
+// A field in which a stored book and the client's copy of it differ
+type fieldDifference struct {
+	Field  string      `json:"field"`
+	Stored interface{} `json:"stored"`
+	Given  interface{} `json:"given"`
+}
+
+// A book both sides have, with the fields that differ
+type changedBook struct {
+	ID     string            `json:"id"`
+	Index  int               `json:"index"`
+	Fields []fieldDifference `json:"fields"`
+}
+
+// How a client's snapshot differs from the catalog. New are the books only
+// the snapshot has, Missing the ones only the catalog has. Books are
+// matched by the key the duplicate check uses: the ISBN and edition, or
+// name, author, year and edition without an ISBN. Duplicates are the
+// indexes of snapshot books whose key appeared before in the snapshot.
+// Missing holds at most limit books, Truncated is set when there were more.
+type snapshotDiff struct {
+	New        []int         `json:"new"`
+	Missing    []Book        `json:"missing"`
+	Changed    []changedBook `json:"changed"`
+	Unchanged  int           `json:"unchanged"`
+	Duplicates []int         `json:"duplicates"`
+	Truncated  bool          `json:"truncated"`
+}
+
+// Compares the client's books with the catalog without changing anything.
+// The catalog is streamed rather than loaded, so memory grows with the
+// size of the snapshot and limit only.
+func diffSnapshot(ctx context.Context, coll *mongo.Collection, books []Book, limit int) (snapshotDiff, error) {
+	diff := snapshotDiff{New: []int{}, Missing: []Book{}, Changed: []changedBook{}, Duplicates: []int{}}
+	given := map[string]int{}
+	for i, book := range books {
+		// Keys compare canonical ISBNs, whichever way they are stored
+		key, _ := duplicateKeys(convertToBookstore(book, isbnConversion{}))
+		if _, ok := given[key]; ok {
+			diff.Duplicates = append(diff.Duplicates, i)
+			continue
+		}
+		given[key] = i
+	}
+
+	opts := options.Find().SetSort(stableSort(nil)).SetBatchSize(isbnScanBatchSize)
+	cursor, err := coll.Find(ctx, bson.D{}, opts, findTimeLimit(ctx))
+	if err != nil {
+		return diff, err
+	}
+	defer cursor.Close(ctx)
+	matched := map[int]bool{}
+	for cursor.Next(ctx) {
+		var stored BookStore
+		if err := cursor.Decode(&stored); err != nil {
+			return diff, err
+		}
+		key, _ := duplicateKeys(stored)
+		i, ok := given[key]
+		if !ok || matched[i] {
+			if len(diff.Missing) == limit {
+				diff.Truncated = true
+				continue
+			}
+			diff.Missing = append(diff.Missing, convertToBook(stored))
+			continue
+		}
+		matched[i] = true
+		if fields := bookDifferences(convertToBook(stored), books[i]); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, changedBook{ID: stored.ID.Hex(), Index: i, Fields: fields})
+		} else {
+			diff.Unchanged++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return diff, err
+	}
+	for i := range books {
+		if !matched[i] && !slices.Contains(diff.Duplicates, i) {
+			diff.New = append(diff.New, i)
+		}
+	}
+	return diff, nil
+}
+
+// Compares the fields a client can set. ISBNs are compared normalized and
+// tags regardless of their order; the id, version and lock are the
+// server's and not compared.
+func bookDifferences(stored, given Book) []fieldDifference {
+	var fields []fieldDifference
+	differs := func(field string, a, b interface{}) {
+		fields = append(fields, fieldDifference{Field: field, Stored: a, Given: b})
+	}
+	for _, f := range []struct {
+		name          string
+		stored, given string
+	}{
+		{"name", stored.Name, given.Name},
+		{"subtitle", stored.Subtitle, given.Subtitle},
+		{"edition", stored.Edition, given.Edition},
+		{"author", stored.Author, given.Author},
+	} {
+		if f.stored != f.given {
+			differs(f.name, f.stored, f.given)
+		}
+	}
+	if canonicalISBN(stored.ISBN) != canonicalISBN(given.ISBN) {
+		differs("isbn", stored.ISBN, given.ISBN)
+	}
+	if stored.Pages != given.Pages {
+		differs("pages", stored.Pages, given.Pages)
+	}
+	if stored.Year != given.Year {
+		differs("year", stored.Year, given.Year)
+	}
+	if !sameTags(stored.Tags, given.Tags) {
+		differs("tags", stored.Tags, given.Tags)
+	}
+	return fields
+}
+
+func sameTags(stored, given []string) bool {
+	a := slices.Clone(stored)
+	b := make([]string, len(given))
+	for i, tag := range given {
+		b[i] = normalizeTag(tag)
+	}
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
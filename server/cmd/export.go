@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Columns the CSV export can produce, in their default order
+var csvColumns = []string{"id", "name", "subtitle", "edition", "author", "isbn", "pages", "year", "cover"}
+
+// The value of a column for a book
+func csvValue(book BookStore, column string) string {
+	switch column {
+	case "id":
+		return book.ID.Hex()
+	case "name":
+		return book.BookName
+	case "subtitle":
+		return book.BookSubtitle
+	case "edition":
+		return book.BookEdition
+	case "author":
+		return book.BookAuthor
+	case "isbn":
+		return book.BookISBN
+	case "pages":
+		return strconv.Itoa(book.BookPages)
+	case "year":
+		return strconv.Itoa(book.BookYear)
+	case "cover":
+		return book.BookCover
+	}
+	return ""
+}
+
+// Reads the delimiter and fields parameters of the export. The delimiter is
+// "comma" or "semicolon" (or the character itself); fields is a
+// comma-separated list of columns in the order they are written.
+func parseCSVOptions(c echo.Context) (rune, []string, error) {
+	delimiter := ','
+	switch c.QueryParam("delimiter") {
+	case "", "comma", ",":
+	case "semicolon", ";":
+		delimiter = ';'
+	default:
+		return 0, nil, fmt.Errorf("delimiter must be comma or semicolon, not %q", c.QueryParam("delimiter"))
+	}
+
+	value := c.QueryParam("fields")
+	if value == "" {
+		return delimiter, csvColumns, nil
+	}
+	var columns []string
+	seen := map[string]bool{}
+	for _, column := range strings.Split(value, ",") {
+		column = strings.TrimSpace(column)
+		if !slices.Contains(csvColumns, column) {
+			return 0, nil, fmt.Errorf("unknown field %q, expected one of %s", column, strings.Join(csvColumns, ", "))
+		}
+		if seen[column] {
+			return 0, nil, fmt.Errorf("field %q is listed twice", column)
+		}
+		seen[column] = true
+		columns = append(columns, column)
+	}
+	return delimiter, columns, nil
+}
+
+// Streams the books matching filter as CSV, one document at a time, so
+// exporting the whole catalog does not hold it in memory. The csv writer
+// buffers a few kilobytes and passes them on as it goes. QUERY_MAX_TIME
+// does not apply: the status is sent before the cursor is read to the
+// end, so an aborted query would leave a truncated file that looks whole.
+func writeCSV(c echo.Context, coll *mongo.Collection, filter bson.M, delimiter rune, columns []string) error {
+	ctx := c.Request().Context()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return respondDBError(c, err, "Could not export the books")
+	}
+	defer cursor.Close(ctx)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="books.csv"`)
+	res.WriteHeader(200)
+
+	w := csv.NewWriter(res)
+	w.Comma = delimiter
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
+	for cursor.Next(ctx) {
+		var book BookStore
+		if err := cursor.Decode(&book); err != nil {
+			return err
+		}
+		for i, column := range columns {
+			row[i] = csvValue(book, column)
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	// The status is sent already, all that is left is to log the failure
+	if err := cursor.Err(); err != nil {
+		logger.ErrorContext(c.Request().Context(), "csv export aborted", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Number of books published in the RSS feed
+const feedSize = 20
+
+// RSS 2.0 document, see https://www.rssboard.org/rss-specification.
+// The author is not an e-mail address, so it goes into dc:creator instead of
+// the RSS author element.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Creator string  `xml:"dc:creator"`
+	GUID    rssGUID `xml:"guid"`
+	PubDate string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Returns the most recently added books, newest first. ObjectIDs start with
+// their creation time, so sorting on _id also covers documents without
+// CreatedAt.
+func findRecentBooks(ctx context.Context, coll *mongo.Collection, limit int64) ([]BookStore, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(limit)
+	cursor, err := coll.Find(ctx, bson.D{}, opts, findTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var results []BookStore
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func renderFeed(books []BookStore, link string) ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       "Book Store: new arrivals",
+			Link:        link,
+			Description: "The latest books added to the catalog",
+		},
+	}
+	for _, book := range books {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:   book.BookName,
+			Creator: book.BookAuthor,
+			GUID:    rssGUID{Value: book.ID.Hex()},
+			PubDate: addedAt(book).UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
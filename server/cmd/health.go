@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How long the health report may take altogether. The checks are cheap, so
+// running into this means the database is in trouble.
+const healthTimeout = 2 * time.Second
+
+// Indexes the server creates at startup, by collection and name
+var expectedIndexes = map[string][]string{
+	"information": {"_id_", searchIndexName, "year"},
+	"history":     {"_id_", "bookid_1_at_1"},
+}
+
+type indexStatus struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Present    bool   `json:"present"`
+}
+
+// State of the data store as shown to on-call engineers. Status is "ok",
+// "degraded" when indexes are missing or a migration is pending, or
+// "unavailable" when the database could not be asked. A pending migration
+// makes it "unavailable" as well when the schema is required to be current.
+type healthReport struct {
+	Status         string        `json:"status"`
+	MongoVersion   string        `json:"mongoVersion,omitempty"`
+	Books          int64         `json:"books"`
+	Indexes        []indexStatus `json:"indexes"`
+	Schema         *schemaStatus `json:"schema,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	DurationMillis int64         `json:"durationMillis"`
+}
+
+// Collects the health report. The book count is the estimate from the
+// collection's metadata, so no documents are read.
+func checkHealth(ctx context.Context, coll, hist, meta *mongo.Collection, requireSchema bool) healthReport {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok", Indexes: []indexStatus{}}
+	err := func() error {
+		var info struct {
+			Version string `bson:"version"`
+		}
+		if err := coll.Database().RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info); err != nil {
+			return err
+		}
+		report.MongoVersion = info.Version
+
+		count, err := coll.EstimatedDocumentCount(ctx)
+		if err != nil {
+			return err
+		}
+		report.Books = count
+
+		for _, c := range []*mongo.Collection{coll, hist} {
+			specs, err := c.Indexes().ListSpecifications(ctx)
+			if err != nil {
+				return err
+			}
+			present := map[string]bool{}
+			for _, spec := range specs {
+				present[spec.Name] = true
+			}
+			for _, name := range expectedIndexes[c.Name()] {
+				report.Indexes = append(report.Indexes, indexStatus{Collection: c.Name(), Name: name, Present: present[name]})
+				if !present[name] {
+					report.Status = "degraded"
+				}
+			}
+		}
+
+		schema, err := readSchemaStatus(ctx, meta)
+		if err != nil {
+			return err
+		}
+		report.Schema = &schema
+		if schema.Pending && requireSchema {
+			return fmt.Errorf("the data is on schema version %d, the server needs %d: run POST /api/admin/migrate", schema.Version, schema.Current)
+		}
+		if schema.Pending {
+			report.Status = "degraded"
+		}
+		return nil
+	}()
+	if err != nil {
+		report.Status = "unavailable"
+		report.Error = err.Error()
+	}
+	report.DurationMillis = time.Since(start).Milliseconds()
+	return report
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Largest number of books accepted by a single import
+const maxImportRows = 1000
+
+// Outcome of a single row of an import
+const (
+	importInserted = "inserted"
+	importSkipped  = "skipped"
+	importFailed   = "failed"
+)
+
+type importRow struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	// Citation key of the entry of a BibTeX import
+	Key string `json:"key,omitempty"`
+	// Normalized ISBN of the row, so clients can tell which ISBNs were
+	// inserted and which were skipped as duplicates
+	ISBN   string   `json:"isbn,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+	// Issues of rows that were accepted nonetheless, see bookWarnings
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Result of an import. For a dry run it describes what would have happened;
+// nothing has been written then.
+type importReport struct {
+	DryRun   bool        `json:"dryRun"`
+	Inserted int         `json:"inserted"`
+	Skipped  int         `json:"skipped"`
+	Failed   int         `json:"failed"`
+	Rows     []importRow `json:"rows"`
+}
+
+// Validates every row and checks it for duplicates, both against the
+// database and against the rows before it. Returns the report together
+// with the books that are to be inserted, keyed by their row index. Dry
+// runs and real imports share this step, so a dry run reports exactly what
+// the import would do. The reasons for failed and skipped rows are given in
+// lang. Strict imports fail rows with warnings, see checkBook.
+func planImport(ctx context.Context, coll *mongo.Collection, books []Book, isbns isbnConversion, lang string, strict bool) (importReport, map[int]BookStore) {
+	report := importReport{Rows: make([]importRow, len(books))}
+	planned := map[int]BookStore{}
+	seen := map[string]bool{}
+
+	for i, book := range books {
+		row := importRow{Index: i, ISBN: normalizeISBN(book.ISBN)}
+		toInsert := convertToBookstore(book, isbns)
+		// Imported books always get a new id
+		toInsert.ID = primitive.ObjectID{}
+		key, occupied := duplicateKeys(toInsert)
+		problems, warnings := checkBook(book, strict)
+		if len(warnings) > 0 {
+			row.Warnings = messages(warnings, lang)
+		}
+		switch {
+		case len(problems) > 0:
+			row.Status = importFailed
+			row.Errors = messages(problems, lang)
+		case seen[key]:
+			row.Status = importSkipped
+			row.Errors = []string{newProblem("duplicate_in_batch").message(lang)}
+		case checkIfDuplicateExists(ctx, coll, toInsert):
+			row.Status = importSkipped
+			row.Errors = []string{newProblem("already_in_catalog").message(lang)}
+		default:
+			row.Status = importInserted
+			planned[i] = toInsert
+			// Only a row that is inserted makes later ones duplicates; an
+			// invalid one must not keep its valid copy out
+			for _, k := range occupied {
+				seen[k] = true
+			}
+		}
+		report.Rows[i] = row
+	}
+	report.count()
+	return report, planned
+}
+
+// Inserts the planned books in one round trip. Rows the database refuses
+// are marked as failed in the report; the others are inserted regardless.
+// A row refused by a unique index was stored by somebody else since it was
+// planned and is skipped as a duplicate instead.
+func runImport(ctx context.Context, coll *mongo.Collection, hist *mongo.Collection, webhooks *webhookNotifier, user, lang string, report *importReport, planned map[int]BookStore) error {
+	if len(planned) == 0 {
+		return nil
+	}
+	indexes := make([]int, 0, len(planned))
+	docs := make([]interface{}, 0, len(planned))
+	now := time.Now().UTC()
+	for i := range report.Rows {
+		book, ok := planned[i]
+		if !ok {
+			continue
+		}
+		book.ID = primitive.NewObjectID()
+		book.CreatedAt = now
+		planned[i] = book
+		indexes = append(indexes, i)
+		docs = append(docs, book)
+	}
+
+	_, err := coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	failed := map[int]string{}
+	duplicates := map[int]bool{}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if mongo.IsDuplicateKeyError(writeErr) {
+				duplicates[indexes[writeErr.Index]] = true
+			} else {
+				failed[indexes[writeErr.Index]] = writeErr.Message
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, i := range indexes {
+		if msg, ok := failed[i]; ok {
+			report.Rows[i].Status = importFailed
+			report.Rows[i].Errors = []string{msg}
+			continue
+		}
+		if duplicates[i] {
+			report.Rows[i].Status = importSkipped
+			report.Rows[i].Errors = []string{newProblem("already_in_catalog").message(lang)}
+			continue
+		}
+		book := planned[i]
+		report.Rows[i].ID = book.ID.Hex()
+		recordAudit(ctx, hist, webhooks, actionCreated, user, nil, &book)
+	}
+	report.count()
+	return nil
+}
+
+func (r *importReport) count() {
+	r.Inserted, r.Skipped, r.Failed = 0, 0, 0
+	for _, row := range r.Rows {
+		switch row.Status {
+		case importInserted:
+			r.Inserted++
+		case importSkipped:
+			r.Skipped++
+		case importFailed:
+			r.Failed++
+		}
+	}
+}
+
+// Applies the rules of checkIfDuplicateExists within a batch. Returns the
+// key a book is looked up by, and the keys it takes for the rows after it:
+// a book with an ISBN still clashes with a later one without an ISBN but
+// the same name, author and year.
+func duplicateKeys(book BookStore) (string, []string) {
+	byName := "name\x00" + strings.Join([]string{book.BookName, book.BookAuthor, fmt.Sprint(book.BookYear), book.BookEdition}, "\x00")
+	isbn := canonicalISBN(book.BookISBN)
+	if isbn == "" {
+		return byName, []string{byName}
+	}
+	byISBN := "isbn\x00" + isbn + "\x00" + book.BookEdition
+	return byISBN, []string{byISBN, byName}
+}
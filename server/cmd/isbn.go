@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Largest number of ISBNs a single lookup may ask for
+const maxLookupISBNs = 500
+
+// Reduces an ISBN to its digits and the X check character, so differently
+// formatted spellings compare equal: "978-3-649-64609-9" and
+// "978 3649646099" both become "9783649646099".
+func normalizeISBN(isbn string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(isbn) {
+		if (r >= '0' && r <= '9') || r == 'X' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Aggregation expression that normalizes the stored ISBN of a document the
+// way normalizeISBN does, for what is actually found in the data: hyphens,
+// spaces and an "ISBN" or "ISBN:" in front, which isValidISBN accepts.
+// Books are stored with the ISBN as it was entered, so queries by ISBN
+// compare against this instead of the raw field.
+var normalizedISBNExpr = func() interface{} {
+	var expr interface{} = bson.M{"$toUpper": bson.M{"$ifNull": bson.A{"$bookisbn", ""}}}
+	for _, noise := range []string{"ISBN", ":", "-", " "} {
+		expr = bson.M{"$replaceAll": bson.M{"input": expr, "find": noise, "replacement": ""}}
+	}
+	return expr
+}()
+
+// Reports whether isbn is a well-formed ISBN-10 or ISBN-13 with a correct
+// check digit. Besides digits and the X, which is only allowed as the
+// check digit of an ISBN-10, it may contain hyphens and spaces and start
+// with "ISBN".
+func isValidISBN(isbn string) bool {
+	rest := strings.TrimSpace(isbn)
+	if len(rest) >= 4 && strings.EqualFold(rest[:4], "ISBN") {
+		rest = strings.TrimLeft(rest[4:], ": ")
+	}
+	if strings.Trim(strings.ToUpper(rest), "0123456789X- ") != "" {
+		return false
+	}
+	digits := normalizeISBN(rest)
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, r := range digits {
+			d := int(r - '0')
+			if r == 'X' {
+				if i != 9 {
+					return false
+				}
+				d = 10
+			}
+			sum += (10 - i) * d
+		}
+		return sum%11 == 0
+	case 13:
+		if strings.ContainsRune(digits, 'X') {
+			return false
+		}
+		return isbn13CheckDigit(digits[:12]) == digits[12]
+	}
+	return false
+}
+
+// Check digit of an ISBN-13 given its first 12 digits
+func isbn13CheckDigit(first12 string) byte {
+	sum := 0
+	for i, r := range first12 {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Check character of an ISBN-10 given its first 9 digits
+func isbn10CheckDigit(first9 string) byte {
+	sum := 0
+	for i, r := range first9 {
+		sum += (10 - i) * int(r-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// Lengths of the registration groups after an ISBN-13 prefix, by the first
+// digits of the group, from the ranges of the International ISBN Agency.
+// Publisher and title are split by much finer ranges per group, which are
+// not kept here.
+var isbnGroupLengths = map[string][]struct {
+	from, to string
+}{
+	"978": {{"0", "5"}, {"600", "649"}, {"65", "65"}, {"7", "7"}, {"80", "94"}, {"950", "989"}, {"9900", "9989"}, {"99900", "99999"}},
+	"979": {{"10", "13"}, {"8", "8"}},
+}
+
+// What GET /api/isbn/validate tells about an ISBN
+type isbnInfo struct {
+	Valid bool `json:"valid"`
+	// 10 or 13, 0 for an invalid ISBN
+	Type       int    `json:"type,omitempty"`
+	Normalized string `json:"normalized,omitempty"`
+	ISBN13     string `json:"isbn13,omitempty"`
+	// Only books with the 978 prefix have an ISBN-10
+	ISBN10 string `json:"isbn10,omitempty"`
+	// Prefix, registration group, publisher and title, and check digit.
+	// The group is only split off for the groups in isbnGroupLengths.
+	Hyphenated string `json:"hyphenated,omitempty"`
+}
+
+func describeISBN(isbn string) isbnInfo {
+	if !isValidISBN(isbn) {
+		return isbnInfo{}
+	}
+	digits := normalizeISBN(isbn)
+	info := isbnInfo{Valid: true, Type: len(digits), Normalized: digits}
+	isbn13 := digits
+	if len(digits) == 10 {
+		isbn13 = "978" + digits[:9]
+		isbn13 += string(isbn13CheckDigit(isbn13))
+	}
+	info.ISBN13 = isbn13
+	if strings.HasPrefix(isbn13, "978") {
+		info.ISBN10 = isbn13[3:12] + string(isbn10CheckDigit(isbn13[3:12]))
+	}
+	info.Hyphenated = hyphenateISBN13(isbn13)
+	return info
+}
+
+// Form of an ISBN that does not depend on how it is written: the ISBN-13
+// of a valid ISBN, so an ISBN-10 equals its ISBN-13, and the digits of
+// anything else
+func canonicalISBN(isbn string) string {
+	if info := describeISBN(isbn); info.Valid {
+		return info.ISBN13
+	}
+	return normalizeISBN(isbn)
+}
+
+// The normalized spellings an ISBN may be stored under: its ISBN-13 and
+// ISBN-10 when it is valid, the digits otherwise
+func equivalentISBNs(isbn string) []string {
+	info := describeISBN(isbn)
+	switch {
+	case !info.Valid:
+		return []string{normalizeISBN(isbn)}
+	case info.ISBN10 != "":
+		return []string{info.ISBN13, info.ISBN10}
+	}
+	return []string{info.ISBN13}
+}
+
+// Conversion of ISBNs on save, from CONVERT_ISBN10 and KEEP_ORIGINAL_ISBN
+type isbnConversion struct {
+	convert      bool
+	keepOriginal bool
+}
+
+// Returns the ISBN the way it is stored. With CONVERT_ISBN10 a valid
+// ISBN-10 is stored as its hyphenated ISBN-13, and the ISBN as it was
+// sent comes back as original if KEEP_ORIGINAL_ISBN is set. Anything else
+// is stored as it is.
+func (conv isbnConversion) storedISBN(isbn string) (stored, original string) {
+	if !conv.convert {
+		return isbn, ""
+	}
+	info := describeISBN(isbn)
+	if !info.Valid || info.Type != 10 {
+		return isbn, ""
+	}
+	if conv.keepOriginal {
+		original = isbn
+	}
+	return info.Hyphenated, original
+}
+
+func hyphenateISBN13(isbn13 string) string {
+	prefix, body, check := isbn13[:3], isbn13[3:12], isbn13[12:]
+	if group, ok := isbnGroup(isbn13); ok {
+		return prefix + "-" + group + "-" + body[len(group):] + "-" + check
+	}
+	return prefix + "-" + body + "-" + check
+}
+
+// Returns the registration group of an ISBN-13, e.g. "3" for the German
+// speaking countries in 978-3-..., if it is one of isbnGroupLengths
+func isbnGroup(isbn13 string) (string, bool) {
+	body := isbn13[3:12]
+	for _, group := range isbnGroupLengths[isbn13[:3]] {
+		start := body[:len(group.from)]
+		if start >= group.from && start <= group.to {
+			return start, true
+		}
+	}
+	return "", false
+}
+
+// Shortest and longest prefix of /api/books/by-publisher: at least the
+// registration group after 978 or 979, and short of a whole ISBN
+const (
+	minISBNPrefix = 4
+	maxISBNPrefix = 12
+)
+
+var isbnPrefixPattern = regexp.MustCompile(`^[0-9][0-9 -]*$`)
+
+// Filter for the books whose ISBN starts with prefix, an ISBN-13 prefix
+// such as 978-3-649 for a publisher. Hyphens, spaces and a stored "ISBN"
+// in front are ignored, the same as for normalizedISBNExpr, and ISBN-10s
+// match by their ISBN-13. The regular expression is anchored at both ends
+// and counts the digits, so 979 ISBN-13s never pass for ISBN-10s.
+func isbnPrefixFilter(prefix string) (bson.M, error) {
+	if !isbnPrefixPattern.MatchString(prefix) {
+		return nil, fmt.Errorf("the prefix may only contain digits and hyphens")
+	}
+	digits := normalizeISBN(prefix)
+	if len(digits) < minISBNPrefix || len(digits) > maxISBNPrefix {
+		return nil, fmt.Errorf("the prefix must have %d to %d digits", minISBNPrefix, maxISBNPrefix)
+	}
+	if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+		return nil, fmt.Errorf("the prefix must start with 978 or 979")
+	}
+	pattern := isbnDigitsPattern(digits, 13)
+	if strings.HasPrefix(digits, "978") {
+		pattern += "|" + isbnDigitsPattern(digits[3:], 10)
+	}
+	return bson.M{"bookisbn": bson.M{"$regex": pattern, "$options": "i"}}, nil
+}
+
+// Matches ISBNs of total digits that start with the given ones
+func isbnDigitsPattern(digits string, total int) string {
+	var b strings.Builder
+	b.WriteString(`^(?:ISBN)?[-: ]*`)
+	for _, d := range digits {
+		b.WriteRune(d)
+		b.WriteString(`[- ]*`)
+	}
+	fmt.Fprintf(&b, `(?:[0-9X][- ]*){%d}$`, total-len(digits))
+	return b.String()
+}
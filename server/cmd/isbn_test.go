@@ -0,0 +1,117 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Pairs of ISBN-10 and ISBN-13 of the same book
+var knownISBNPairs = []struct {
+	isbn10, isbn13 string
+}{
+	{"0306406152", "9780306406157"},
+	{"0198526636", "9780198526636"},
+	{"080442957X", "9780804429573"},
+	{"316148410X", "9783161484100"},
+}
+
+func TestDescribeISBNPairs(t *testing.T) {
+	for _, pair := range knownISBNPairs {
+		for _, isbn := range []string{pair.isbn10, pair.isbn13} {
+			info := describeISBN(isbn)
+			if !info.Valid || info.ISBN13 != pair.isbn13 || info.ISBN10 != pair.isbn10 {
+				t.Errorf("describeISBN(%q) = %+v, want ISBN-13 %s and ISBN-10 %s", isbn, info, pair.isbn13, pair.isbn10)
+			}
+		}
+	}
+
+	// 979 books have no ISBN-10
+	info := describeISBN("979-10-90636-07-1")
+	if !info.Valid || info.Type != 13 || info.ISBN10 != "" {
+		t.Errorf("describeISBN(979-10-90636-07-1) = %+v, want a valid ISBN-13 without ISBN-10", info)
+	}
+	if info := describeISBN("0-306-40615-3"); info.Valid {
+		t.Errorf("an ISBN-10 with a wrong check digit is valid: %+v", info)
+	}
+}
+
+func TestStoredISBN(t *testing.T) {
+	tests := []struct {
+		isbn, stored, original string
+	}{
+		{"0-306-40615-2", "978-0-30640615-7", "0-306-40615-2"},
+		{"0306406152", "978-0-30640615-7", "0306406152"},
+		{"0-8044-2957-X", "978-0-80442957-3", "0-8044-2957-X"},
+		{"0-8044-2957-x", "978-0-80442957-3", "0-8044-2957-x"},
+		{"3-16-148410-X", "978-3-16148410-0", "3-16-148410-X"},
+		// ISBN-13s, the 979 ones included, are stored as they are
+		{"978-0-306-40615-7", "978-0-306-40615-7", ""},
+		{"979-10-90636-07-1", "979-10-90636-07-1", ""},
+		{"9791090636071", "9791090636071", ""},
+		// Invalid ISBNs are left for the validation to report
+		{"0-306-40615-3", "0-306-40615-3", ""},
+		{"", "", ""},
+	}
+
+	conv := isbnConversion{convert: true, keepOriginal: true}
+	for _, test := range tests {
+		stored, original := conv.storedISBN(test.isbn)
+		if stored != test.stored || original != test.original {
+			t.Errorf("storedISBN(%q) = %q, %q, want %q, %q", test.isbn, stored, original, test.stored, test.original)
+		}
+	}
+
+	conv = isbnConversion{convert: true}
+	if stored, original := conv.storedISBN("0306406152"); stored != "978-0-30640615-7" || original != "" {
+		t.Errorf("without KEEP_ORIGINAL_ISBN got %q, %q", stored, original)
+	}
+
+	conv = isbnConversion{keepOriginal: true}
+	if stored, original := conv.storedISBN("0306406152"); stored != "0306406152" || original != "" {
+		t.Errorf("without CONVERT_ISBN10 got %q, %q", stored, original)
+	}
+}
+
+// Duplicate checks and lookups find a book under either of its ISBNs
+func TestEquivalentISBNs(t *testing.T) {
+	for _, pair := range knownISBNPairs {
+		want := []string{pair.isbn13, pair.isbn10}
+		for _, isbn := range []string{pair.isbn10, pair.isbn13} {
+			if got := equivalentISBNs(isbn); !slices.Equal(got, want) {
+				t.Errorf("equivalentISBNs(%q) = %q, want %q", isbn, got, want)
+			}
+			if got := canonicalISBN(isbn); got != pair.isbn13 {
+				t.Errorf("canonicalISBN(%q) = %q, want %q", isbn, got, pair.isbn13)
+			}
+		}
+	}
+	if got := equivalentISBNs("979-10-90636-07-1"); !slices.Equal(got, []string{"9791090636071"}) {
+		t.Errorf("equivalentISBNs of a 979 ISBN = %q", got)
+	}
+	if got := equivalentISBNs("12-34"); !slices.Equal(got, []string{"1234"}) {
+		t.Errorf("equivalentISBNs of an invalid ISBN = %q", got)
+	}
+}
+
+// Stored ISBNs may carry anything isValidISBN accepts, the prefix included
+func TestISBNPrefixFilterPattern(t *testing.T) {
+	filter, err := isbnPrefixFilter("978-0-306")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := regexp.MustCompile("(?i)" + filter["bookisbn"].(bson.M)["$regex"].(string))
+	for _, isbn := range []string{"978-0-306-40615-7", "9780306406157", "ISBN 978-0-306-40615-7", "isbn: 0-306-40615-2"} {
+		if !isValidISBN(isbn) {
+			t.Fatalf("%q is no valid ISBN", isbn)
+		}
+		if !pattern.MatchString(isbn) {
+			t.Errorf("%q does not match the publisher 978-0-306", isbn)
+		}
+	}
+	if pattern.MatchString("978-3-16-148410-0") {
+		t.Error("another publisher matches 978-0-306")
+	}
+}
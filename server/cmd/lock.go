@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errLocked = errors.New("the book is locked by somebody else")
+
+// Marks a book as being edited by Owner. A lock that has expired is
+// treated as if it did not exist, so a lock abandoned by a closed editor
+// goes away by itself.
+type bookLock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (l *bookLock) active(now time.Time) bool {
+	return l != nil && now.Before(l.ExpiresAt)
+}
+
+// Reports whether somebody other than owner, see lockOwner, holds an active
+// lock on book. Every active lock counts for the owner "".
+func lockedByOther(book BookStore, owner string) bool {
+	return book.Lock.active(time.Now()) && book.Lock.Owner != owner
+}
+
+// Filter for the books nobody other than owner holds an active lock of at
+// the time now, the database side of lockedByOther
+func unlockedFilter(owner string, now time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"lock": bson.M{"$exists": false}},
+		bson.M{"lock.expiresat": bson.M{"$lte": now}},
+		bson.M{"lock.owner": owner},
+	}}
+}
+
+// Answers an edit of a book that somebody else has locked
+func respondLocked(c echo.Context, lock *bookLock) error {
+	return respondProblem(c, 423, newProblem("book_locked", lock.Owner, lock.ExpiresAt.UTC().Format(time.RFC3339)))
+}
+
+// Locks the book for user for the given time, or extends the lock user
+// already holds. It fails with the book as it is stored when somebody else
+// holds the lock, and with mongo.ErrNoDocuments when there is no such book.
+func lockBook(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, user string, ttl time.Duration) (BookStore, error) {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": id, "$and": bson.A{unlockedFilter(user, now)}}
+	update := bson.M{"$set": bson.M{"lock": bookLock{Owner: user, ExpiresAt: now.Add(ttl)}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var book BookStore
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&book)
+	if err != mongo.ErrNoDocuments {
+		return book, err
+	}
+	if book, err = findBookByID(ctx, coll, id); err != nil {
+		return book, err
+	}
+	return book, errLocked
+}
+
+// Releases the lock user holds on the book. Releasing a lock that has
+// expired or was never taken succeeds as well; a lock held by somebody else
+// fails with errLocked and the book as it is stored.
+func unlockBook(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, user string) (BookStore, error) {
+	filter := bson.M{"_id": id, "$or": bson.A{
+		bson.M{"lock.owner": user},
+		bson.M{"lock.expiresat": bson.M{"$lte": time.Now().UTC()}},
+	}}
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"lock": ""}})
+	if err != nil || res.MatchedCount > 0 {
+		return BookStore{}, err
+	}
+	book, err := findBookByID(ctx, coll, id)
+	if err == nil && lockedByOther(book, user) {
+		err = errLocked
+	}
+	return book, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Structured logger for everything the server logs, access log included. It
+// writes JSON to stdout and adds the request id to every line logged with
+// the context of a request.
+var logger = slog.New(requestIDHandler{slog.NewJSONHandler(os.Stdout, nil)})
+
+type requestIDKey struct{}
+
+// Returns ctx carrying the id of the request it belongs to
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Returns the request id stored in ctx, or "" outside of a request
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Log handler that adds the request id of the context to the record
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// Gives every request an id in the given header. An id sent by the client,
+// usually a gateway that started a trace, is kept; otherwise one is
+// generated. The id is sent back in the same header and stored in the
+// request context for the logs.
+func requestIDMiddleware(header string) echo.MiddlewareFunc {
+	return middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		TargetHeader: header,
+		RequestIDHandler: func(c echo.Context, id string) {
+			req := c.Request()
+			c.SetRequest(req.WithContext(withRequestID(req.Context(), id)))
+		},
+	})
+}
+
+// Writes one access log line per request through logger
+func accessLogMiddleware() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		HandleError:     true,
+		LogMethod:       true,
+		LogURI:          true,
+		LogStatus:       true,
+		LogLatency:      true,
+		LogRemoteIP:     true,
+		LogUserAgent:    true,
+		LogResponseSize: true,
+		LogError:        true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			attrs := []slog.Attr{
+				slog.String("method", v.Method),
+				slog.String("uri", v.URI),
+				slog.Int("status", v.Status),
+				slog.Duration("latency", v.Latency),
+				slog.String("remote_ip", v.RemoteIP),
+				slog.String("user_agent", v.UserAgent),
+				slog.Int64("bytes_out", v.ResponseSize),
+			}
+			level := slog.LevelInfo
+			if v.Error != nil {
+				level = slog.LevelError
+				attrs = append(attrs, slog.String("error", v.Error.Error()))
+			}
+			logger.LogAttrs(c.Request().Context(), level, "request", attrs...)
+			return nil
+		},
+	})
+}
+
+// Logs the request and response bodies of the routes below apiPrefix,
+// except for the routes in skip, whose responses never end. Bodies longer
+// than max bytes are cut off so a large import does not flood the logs.
+func bodyDumpMiddleware(max int, apiPrefix string, skip ...string) echo.MiddlewareFunc {
+	return middleware.BodyDumpWithConfig(middleware.BodyDumpConfig{
+		Skipper: func(c echo.Context) bool {
+			return !strings.HasPrefix(c.Path(), apiPrefix) || slices.Contains(skip, c.Path())
+		},
+		Handler: func(c echo.Context, reqBody, resBody []byte) {
+			logger.InfoContext(c.Request().Context(), "http body",
+				"method", c.Request().Method,
+				"uri", c.Request().RequestURI,
+				"status", c.Response().Status,
+				"request", truncateBody(reqBody, max),
+				"response", truncateBody(resBody, max),
+			)
+		},
+	})
+}
+
+func truncateBody(body []byte, max int) string {
+	if len(body) <= max {
+		return string(body)
+	}
+	cut := strings.ToValidUTF8(string(body[:max]), "")
+	return fmt.Sprintf("%s... (%d bytes total)", cut, len(body))
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -16,26 +24,61 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 // Defines a "model" that we can use to communicate with the
 // frontend or the database
 type BookStore struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	BookName   string
-	BookAuthor string
-	BookISBN   string
-	BookPages  int
-	BookYear   int
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	BookName string
+	// Optional, stored only when set like the other later additions
+	BookSubtitle string `bson:",omitempty"`
+	BookEdition  string `bson:",omitempty"`
+	BookAuthor   string
+	BookISBN     string
+	// The ISBN-10 the book was sent with before CONVERT_ISBN10 replaced
+	// it, if KEEP_ORIGINAL_ISBN is set
+	OriginalISBN string `bson:",omitempty"`
+	BookPages    int
+	BookYear     int
+	// URL of the uploaded cover image, if any
+	BookCover string `bson:",omitempty"`
+	// Labels set by curators, normalized with normalizeTag
+	BookTags []string `bson:",omitempty"`
+	// Set when the book is first stored. Older documents do not have it,
+	// see addedAt.
+	CreatedAt time.Time `bson:",omitempty"`
+	// Incremented on every update, starting from 0 for a new book
+	Version int64 `bson:",omitempty"`
+	// Set while somebody is editing the book, see lockBook
+	Lock *bookLock `bson:",omitempty"`
+	// Position on the curated shelf, starting at 1. Unset for books that
+	// are not on it, see setDisplayOrder.
+	DisplayOrder int `bson:",omitempty"`
 }
 
 type Book struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Author string `json:"author"`
-	ISBN   string `json:"isbn"`
-	Pages  int    `json:"pages"`
-	Year   int    `json:"year"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Subtitle string `json:"subtitle,omitempty"`
+	Edition  string `json:"edition,omitempty"`
+	Author   string `json:"author"`
+	ISBN     string `json:"isbn"`
+	// Set by the server, see isbnConversion
+	OriginalISBN string `json:"originalIsbn,omitempty"`
+	Pages        int    `json:"pages"`
+	Year         int    `json:"year"`
+	Cover        string `json:"cover,omitempty"`
+	// Changed through /api/books/:id/tags only
+	Tags []string `json:"tags,omitempty"`
+	// Set by the server; changes sent by clients are ignored
+	Version int64 `json:"version,omitempty"`
+	// Who is editing the book right now, if anybody. Set by the server.
+	Lock *bookLock `json:"lock,omitempty"`
+	// Changed through PUT /api/books/order only
+	DisplayOrder int `json:"displayOrder,omitempty"`
 }
 
 // Wraps the "Template" struct to associate a necessary method
@@ -52,10 +95,43 @@ type Template struct {
 // to get to know more about templating
 // You can also read Golang's documentation on their templating
 // https://pkg.go.dev/text/template
-func loadTemplates() *Template {
-	return &Template{
-		tmpl: template.Must(template.ParseGlob("views/*.html")),
+//
+// html/template escapes every value according to where it ends up, so book
+// data is always rendered as text. The helpers below only format values;
+// none of them may return template.HTML or similar, which would bypass the
+// escaping.
+func loadTemplates() (*Template, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).ParseGlob("views/*.html")
+	if err != nil {
+		return nil, err
 	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Helpers available in the views
+var templateFuncs = template.FuncMap{
+	// Years of books without one are stored as 0
+	"year": func(year int) string {
+		if year == 0 {
+			return "–"
+		}
+		return strconv.Itoa(year)
+	},
+	// Cuts s to n characters, never in the middle of one
+	"truncate": func(n int, s string) string {
+		runes := []rune(s)
+		if len(runes) <= n {
+			return s
+		}
+		return strings.TrimSpace(string(runes[:n-1])) + "…"
+	},
+	// Shows a dash for fields that are not filled in
+	"optional": func(s string) string {
+		if strings.TrimSpace(s) == "" {
+			return "–"
+		}
+		return s
+	},
 }
 
 // Method definition of the required "Render" to be passed for the Rendering
@@ -77,15 +153,26 @@ func (t *Template) Render(w io.Writer, name string, data interface{}, ctx echo.C
 // files, that you pass the proper value to ensure communication with the
 // database
 // More on what bson means: https://www.mongodb.com/docs/drivers/go/current/fundamentals/bson/
-func prepareDatabase(client *mongo.Client, dbName string, collecName string) (*mongo.Collection, error) {
-	db := client.Database(dbName)
+//
+// The read preference and write concern, when set, apply to the collection
+// and to the others of the database such as the history. Reads from a
+// secondary may miss a change that was just written.
+func prepareDatabase(client *mongo.Client, dbName string, collecName string, readPref *readpref.ReadPref, writeConcern *writeconcern.WriteConcern) (*mongo.Collection, error) {
+	opts := options.Database()
+	if readPref != nil {
+		opts.SetReadPreference(readPref)
+	}
+	if writeConcern != nil {
+		opts.SetWriteConcern(writeConcern)
+	}
+	db := client.Database(dbName, opts)
 
 	names, err := db.ListCollectionNames(context.TODO(), bson.D{{}})
 	if err != nil {
 		return nil, err
 	}
 	if !slices.Contains(names, collecName) {
-		cmd := bson.D{{"create", collecName}}
+		cmd := bson.D{{Key: "create", Value: collecName}}
 		var result bson.M
 		if err = db.RunCommand(context.TODO(), cmd).Decode(&result); err != nil {
 			log.Fatal(err)
@@ -140,6 +227,7 @@ func prepareData(client *mongo.Client, coll *mongo.Collection) {
 		if len(results) > 1 {
 			log.Fatal("more records were found")
 		} else if len(results) == 0 {
+			book.CreatedAt = time.Now().UTC()
 			result, err := coll.InsertOne(context.TODO(), book)
 			if err != nil {
 				panic(err)
@@ -157,161 +245,367 @@ func prepareData(client *mongo.Client, coll *mongo.Collection) {
 }
 
 // Generic method to perform "SELECT * FROM BOOKS" (if this was SQL, which
-// it is not :D ), and then we convert it into an array of map. In Golang, you
-// define a map by writing map[<key type>]<value type>{<key>:<value>}.
-// interface{} is a special type in Golang, basically a wildcard...
-func findAllBooks(coll *mongo.Collection) []map[string]interface{} {
-	cursor, err := coll.Find(context.TODO(), bson.D{{}})
-	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
+// it is not :D ), and then we convert every document into a Book. The
+// pages render the very same Book the API sends, so the two cannot drift
+// apart.
+func findAllBooks(ctx context.Context, coll *mongo.Collection) []Book {
+	books, err := findBooks(ctx, coll, bson.D{{}}, options.Find().SetSort(stableSort(nil)))
+	if err != nil {
 		panic(err)
 	}
+	return books
+}
 
-	var ret []map[string]interface{}
-	for _, res := range results {
-		ret = append(ret, map[string]interface{}{
-			"ID":         res.ID.Hex(),
-			"BookName":   res.BookName,
-			"BookAuthor": res.BookAuthor,
-			"BookISBN":   res.BookISBN,
-			"BookPages":  res.BookPages,
-		})
+// Builds the filter shared by the listing endpoints from the optional query
+// parameters ?name=, ?author=, ?year=, ?tag= and the page range ?pagesMin=
+// and ?pagesMax=, both inclusive. Parameters that are not given do not
+// restrict the result.
+func bookFilterFromQuery(c echo.Context) (bson.M, error) {
+	filter := bson.M{}
+	if name := c.QueryParam("name"); name != "" {
+		filter["bookname"] = name
 	}
-
-	return ret
+	if author := c.QueryParam("author"); author != "" {
+		filter["bookauthor"] = author
+	}
+	if year := c.QueryParam("year"); year != "" {
+		parsed, err := strconv.Atoi(year)
+		if err != nil {
+			return nil, fmt.Errorf("invalid year %q", year)
+		}
+		filter["bookyear"] = parsed
+	}
+	if tag := c.QueryParam("tag"); tag != "" {
+		filter["booktags"] = normalizeTag(tag)
+	}
+	pages := bson.M{}
+	for _, bound := range []struct{ param, operator string }{{"pagesMin", "$gte"}, {"pagesMax", "$lte"}} {
+		param, operator := bound.param, bound.operator
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number, not %q", param, value)
+		}
+		pages[operator] = parsed
+	}
+	if min, ok := pages["$gte"].(int); ok {
+		if max, ok := pages["$lte"].(int); ok && min > max {
+			return nil, fmt.Errorf("pagesMin (%d) must not be larger than pagesMax (%d)", min, max)
+		}
+	}
+	if len(pages) > 0 {
+		filter["bookpages"] = pages
+	}
+	return filter, nil
 }
 
-func getAllBooks(coll *mongo.Collection) []map[string]interface{} {
-	cursor, err := coll.Find(context.TODO(), bson.D{{}})
+// Returns the books matching the filter in their API representation
+func findBooks(ctx context.Context, coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]Book, error) {
+	cursor, err := coll.Find(ctx, filter, append(opts, findTimeLimit(ctx))...)
+	if err != nil {
+		return nil, err
+	}
 	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		panic(err)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
 	}
-
-	var ret []map[string]interface{}
+	books := make([]Book, 0, len(results))
 	for _, res := range results {
-		ret = append(ret, map[string]interface{}{
-			"id":     res.ID.Hex(),
-			"name":   res.BookName,
-			"author": res.BookAuthor,
-			"isbn":   res.BookISBN,
-			"pages":  res.BookPages,
-			"year":   res.BookYear,
-		})
+		books = append(books, convertToBook(res))
 	}
-
-	return ret
+	return books, nil
 }
 
-func findAllAuthors(coll *mongo.Collection) []map[string]interface{} {
-	cursor, err := coll.Find(context.TODO(), bson.D{{}})
-	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		panic(err)
+// Looks up all books with one of the given ISBNs in a single query. The
+// ISBNs are compared in their normalized form on both sides, and an ISBN-10
+// finds the book stored with its ISBN-13 and the other way round.
+func findBooksByISBN(ctx context.Context, coll *mongo.Collection, isbns []string) ([]Book, error) {
+	normalized := []string{}
+	for _, isbn := range isbns {
+		if n := normalizeISBN(isbn); n != "" {
+			normalized = append(normalized, equivalentISBNs(n)...)
+		}
 	}
-
-	var ret []map[string]interface{}
-	for _, res := range results {
-		ret = append(ret, map[string]interface{}{
-			"ID":         res.ID.Hex(),
-			"BookAuthor": res.BookAuthor,
-		})
+	if len(normalized) == 0 {
+		return []Book{}, nil
 	}
+	opts := options.Find().SetSort(stableSort(nil))
+	return findBooks(ctx, coll, bson.M{"$expr": bson.M{"$in": bson.A{normalizedISBNExpr, normalized}}}, opts)
+}
+
+// Largest number of ids a single batch request may ask for
+const maxBatchIDs = 500
 
-	return ret
+// Answer of POST /api/books/batch. Ids that are not ObjectIDs are listed
+// as invalid, ids of books that do not exist as missing.
+type bookBatch struct {
+	Books   []Book   `json:"books"`
+	Missing []string `json:"missing"`
+	Invalid []string `json:"invalid"`
 }
 
-func findAllYears(coll *mongo.Collection) []map[string]interface{} {
-	cursor, err := coll.Find(context.TODO(), bson.D{{}})
-	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		panic(err)
+// Fetches the books with the given ids in a single query. The books come
+// in the order of ids, each one once.
+func findBooksByIDs(ctx context.Context, coll *mongo.Collection, ids []string) (bookBatch, error) {
+	batch := bookBatch{Books: []Book{}, Missing: []string{}, Invalid: []string{}}
+	var objectIDs []primitive.ObjectID
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			batch.Invalid = append(batch.Invalid, id)
+			continue
+		}
+		if !seen[objectID] {
+			seen[objectID] = true
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+	if len(objectIDs) == 0 {
+		return batch, nil
 	}
 
-	var ret []map[string]interface{}
-	for _, res := range results {
-		ret = append(ret, map[string]interface{}{
-			"ID":       res.ID.Hex(),
-			"BookYear": res.BookYear,
-		})
+	books, err := findBooks(ctx, coll, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return batch, err
+	}
+	found := map[string]Book{}
+	for _, book := range books {
+		found[book.ID] = book
+	}
+	for _, objectID := range objectIDs {
+		if book, ok := found[objectID.Hex()]; ok {
+			batch.Books = append(batch.Books, book)
+		} else {
+			batch.Missing = append(batch.Missing, objectID.Hex())
+		}
+	}
+	return batch, nil
+}
+
+// Returns every year that at least one book was published in, once and in
+// ascending order. Books without a year are left out.
+func findDistinctYears(ctx context.Context, coll *mongo.Collection) ([]int, error) {
+	values, err := coll.Distinct(ctx, "bookyear", bson.M{"bookyear": bson.M{"$ne": nil}}, distinctTimeLimit(ctx))
+	if err != nil {
+		return nil, err
 	}
+	years := make([]int, 0, len(values))
+	for _, value := range values {
+		switch year := value.(type) {
+		case int32:
+			years = append(years, int(year))
+		case int64:
+			years = append(years, int(year))
+		case float64:
+			years = append(years, int(year))
+		}
+	}
+	// Years stored as different number types come back as separate values
+	sort.Ints(years)
+	return slices.Compact(years), nil
+}
 
-	return ret
+// Counts the books matching the filter on the server side, so callers that
+// only need the number do not have to fetch every document.
+func countBooks(ctx context.Context, coll *mongo.Collection, filter bson.M) (int64, error) {
+	return coll.CountDocuments(ctx, filter, countTimeLimit(ctx))
 }
 
-// Returns true if there is a duplicate in the database
-func checkIfDuplicateExists(coll *mongo.Collection, book BookStore) bool {
-	filter := bson.M{
-		"bookname":   book.BookName,
-		"bookauthor": book.BookAuthor,
-		"bookisbn":   book.BookISBN,
-		"bookpages":  book.BookPages,
-		"bookyear":   book.BookYear,
+// Parses the id of a book as sent by a client. Anything but 24 hexadecimal
+// characters is refused, rather than becoming the zero ObjectID and
+// quietly matching nothing.
+func parseBookID(value string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return id, errors.New("Invalid id, expected 24 hexadecimal characters")
 	}
+	return id, nil
+}
+
+func findBookByID(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID) (BookStore, error) {
+	var book BookStore
+	err := coll.FindOne(ctx, bson.M{"_id": id}, findOneTimeLimit(ctx)).Decode(&book)
+	return book, err
+}
 
+// Returns true if there is a duplicate in the database, see duplicateFilter
+func checkIfDuplicateExists(ctx context.Context, coll *mongo.Collection, book BookStore) bool {
 	// Perform the FindOne operation
-	res := coll.FindOne(context.TODO(), filter)
+	res := coll.FindOne(ctx, duplicateFilter(book), findOneTimeLimit(ctx))
 
 	return res.Err() == nil
 }
 
-func saveBook(coll *mongo.Collection, newBook BookStore) []map[string]interface{} {
-	res, err := coll.InsertOne(context.TODO(), newBook)
-	if err != nil {
-		return nil
+// Filter matching the duplicates of book. A book with an ISBN is a
+// duplicate of any other book with the same ISBN, however it is formatted.
+// Books without one, which are mostly old or self-published, are compared
+// by name, author and year instead. The book itself, if it is stored
+// already, is never its own duplicate.
+func duplicateFilter(book BookStore) bson.M {
+	var filter bson.M
+	if isbn := normalizeISBN(book.BookISBN); isbn != "" {
+		// An ISBN-10 and its ISBN-13 are the same book
+		filter = bson.M{"$expr": bson.M{"$in": bson.A{normalizedISBNExpr, equivalentISBNs(isbn)}}}
+	} else {
+		filter = bson.M{
+			"bookname":   book.BookName,
+			"bookauthor": book.BookAuthor,
+			"bookyear":   book.BookYear,
+		}
 	}
+	// Editions of the same book are separate records. Books stored before
+	// editions existed have none.
+	if book.BookEdition != "" {
+		filter["bookedition"] = book.BookEdition
+	} else {
+		filter["bookedition"] = bson.M{"$in": bson.A{nil, ""}}
+	}
+	if !book.ID.IsZero() {
+		filter["_id"] = bson.M{"$ne": book.ID}
+	}
+	return filter
+}
 
-	var ret []map[string]interface{}
-	ret = append(ret, map[string]interface{}{
-		"ID": res.InsertedID,
-	})
-
-	return ret
+// Inserts a new book and returns the id it was given
+func saveBook(ctx context.Context, coll *mongo.Collection, newBook BookStore) (primitive.ObjectID, error) {
+	newBook.CreatedAt = time.Now().UTC()
+	res, err := coll.InsertOne(ctx, newBook)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return res.InsertedID.(primitive.ObjectID), nil
+}
 
+// The fields a client may change, as set by an update
+func editableFields(book BookStore) bson.M {
+	return bson.M{
+		"bookname":     book.BookName,
+		"booksubtitle": book.BookSubtitle,
+		"bookedition":  book.BookEdition,
+		"bookauthor":   book.BookAuthor,
+		"bookisbn":     book.BookISBN,
+		"originalisbn": book.OriginalISBN,
+		"bookpages":    book.BookPages,
+		"bookyear":     book.BookYear,
+	}
 }
 
-func updateBook(coll *mongo.Collection, updatedBook BookStore) {
+func updateBook(ctx context.Context, coll *mongo.Collection, updatedBook BookStore) {
 	filter := bson.M{
 		"_id": updatedBook.ID,
 	}
 
-	update := bson.M{"$set": bson.M{
-		"bookname":   updatedBook.BookName,
-		"bookauthor": updatedBook.BookAuthor,
-		"bookisbn":   updatedBook.BookISBN,
-		"bookpages":  updatedBook.BookPages,
-		"bookyear":   updatedBook.BookYear,
-	}}
+	update := bson.M{"$set": editableFields(updatedBook), "$inc": bson.M{"version": 1}}
 
-	_, err := coll.UpdateOne(context.TODO(), filter, update)
+	_, err := coll.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return
 	}
 }
 
-func deleteBook(coll *mongo.Collection, id primitive.ObjectID) {
+func deleteBook(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID) {
 	filter := bson.M{
 		"_id": id,
 	}
-	_, err := coll.DeleteOne(context.TODO(), filter)
+	_, err := coll.DeleteOne(ctx, filter)
 	if err != nil {
 		return
 	}
 }
 
-func convertToBookstore(book Book) BookStore {
+// Returns when the book was added to the catalog. Documents stored before
+// CreatedAt existed fall back to the creation time encoded in their ObjectID.
+func addedAt(book BookStore) time.Time {
+	if !book.CreatedAt.IsZero() {
+		return book.CreatedAt
+	}
+	return book.ID.Timestamp()
+}
+
+func convertToBookstore(book Book, isbns isbnConversion) BookStore {
 	var bookStore BookStore
 	if book.ID != "" {
 		bookStore.ID, _ = primitive.ObjectIDFromHex(book.ID)
 	}
 	bookStore.BookAuthor = book.Author
-	bookStore.BookISBN = book.ISBN
+	bookStore.BookISBN, bookStore.OriginalISBN = isbns.storedISBN(book.ISBN)
 	bookStore.BookName = book.Name
+	bookStore.BookSubtitle = book.Subtitle
+	bookStore.BookEdition = book.Edition
 	bookStore.BookPages = book.Pages
 	bookStore.BookYear = book.Year
+	// The cover is only set by POST /api/books/:id/cover, which stores the
+	// image it points to
 	return bookStore
 }
 
+// The reverse of convertToBookstore, used to answer with a single book
+func convertToBook(bookStore BookStore) Book {
+	book := Book{
+		ID:           bookStore.ID.Hex(),
+		Name:         bookStore.BookName,
+		Subtitle:     bookStore.BookSubtitle,
+		Edition:      bookStore.BookEdition,
+		Author:       bookStore.BookAuthor,
+		ISBN:         bookStore.BookISBN,
+		OriginalISBN: bookStore.OriginalISBN,
+		Pages:        bookStore.BookPages,
+		Year:         bookStore.BookYear,
+		Cover:        bookStore.BookCover,
+		Tags:         bookStore.BookTags,
+		Version:      bookStore.Version,
+		DisplayOrder: bookStore.DisplayOrder,
+	}
+	if bookStore.Lock.active(time.Now()) {
+		book.Lock = bookStore.Lock
+	}
+	return book
+}
+
+// Registers the webpages and their assets. The page links to its assets
+// and views through the base path.
+func registerPages(r *echo.Group, coll *mongo.Collection, basePath string) {
+	r.Static("/css", "css")
+
+	r.GET("", func(c echo.Context) error {
+		return c.Render(200, "index", map[string]interface{}{"BasePath": basePath})
+	})
+
+	r.GET("/books", func(c echo.Context) error {
+		return c.Render(200, "book-table", findAllBooks(c.Request().Context(), coll))
+	})
+
+	r.GET("/authors", func(c echo.Context) error {
+		return c.Render(200, "author-table", findAllBooks(c.Request().Context(), coll))
+	})
+
+	r.GET("/years", func(c echo.Context) error {
+		return c.Render(200, "year-table", findAllBooks(c.Request().Context(), coll))
+	})
+
+	r.GET("/search", func(c echo.Context) error {
+		return c.Render(200, "search-bar", nil)
+	})
+
+	r.GET("/create", func(c echo.Context) error {
+		return c.NoContent(304)
+	})
+}
+
+// Where the server listens
+const listenAddress = ":3030"
+
+// You can use such name for the database and collection, or come up with
+// one by yourself!
+const (
+	databaseName   = "exercise-1"
+	collectionName = "information"
+)
+
 func main() {
 	// Connect to the database. Such defer keywords are used once the local
 	// context returns; for this case, the local context is the main function
@@ -320,104 +614,1338 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	uri := os.Getenv("DATABASE_URI")
-	if len(uri) == 0 {
-		fmt.Printf("failure to load env variable\n")
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	logConfig(cfg, "address", listenAddress, "database", databaseName, "collection", collectionName)
+
+	uri := cfg.DatabaseURI
+	if err = validateDatabaseURI(uri); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(dbTimingMonitor()))
+	if err != nil {
+		fmt.Printf("%s\n", describeConnectError(err))
+		os.Exit(1)
+	}
+	// Connect does not talk to the server yet, so ping it to find out right
+	// away whether the URI actually works.
+	if err = client.Ping(ctx, nil); err != nil {
+		fmt.Printf("%s\n", describeConnectError(err))
 		os.Exit(1)
 	}
-	// TODO: make sure to pass the proper username, password, and port
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 
 	// This is another way to specify the call of a function. You can define inline
 	// functions (or anonymous functions, similar to the behavior in Python)
 	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
+		if err = client.Disconnect(context.Background()); err != nil {
 			panic(err)
 		}
 	}()
 
-	// You can use such name for the database and collection, or come up with
-	// one by yourself!
-	coll, err := prepareDatabase(client, "exercise-1", "information")
+	coll, err := prepareDatabase(client, databaseName, collectionName, cfg.ReadPreference, cfg.WriteConcern)
+
+	meta := metaCollection(coll)
+	if err = prepareSchemaVersion(ctx, coll, meta); err != nil {
+		fmt.Printf("could not read the schema version: %v\n", err)
+		os.Exit(1)
+	}
 
 	prepareData(client, coll)
 
+	// Everything but the search works without the index, so a database
+	// that cannot build it does not keep the server from starting
+	if err = prepareSearchIndex(coll); err != nil {
+		logger.Warn("could not create the search index, search is unavailable", "error", err)
+	}
+
+	if err = prepareYearIndex(coll); err != nil {
+		fmt.Printf("could not create the year index: %v\n", err)
+		os.Exit(1)
+	}
+
+	hist := historyCollection(coll)
+	if err = prepareHistory(hist); err != nil {
+		fmt.Printf("could not prepare the history collection: %v\n", err)
+		os.Exit(1)
+	}
+
+	counter := &bookCounter{}
+	e, requests, webhooks, err := newServer(cfg, coll, hist, meta, counter)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	// The server runs until SIGINT or SIGTERM arrives, e.g. from docker
+	// stop. Requests that are still being served get SHUTDOWN_TIMEOUT to
+	// complete before the process exits.
+	stop, cancelStop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelStop()
+	if cfg.Warmup {
+		warmUp(stop, coll, counter)
+	}
+	counted := counter.start(stop, coll, cfg.CountRefreshInterval)
+	// Webhooks are delivered until the requests are done, so the changes
+	// they make during the shutdown are sent as well
+	notifying, stopNotifying := context.WithCancel(context.Background())
+	notified := webhooks.start(notifying)
+	go func() {
+		if err := e.Start(listenAddress); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+	<-stop.Done()
+	shutdownServer(e, requests, cfg.ShutdownTimeout)
+	stopNotifying()
+	<-notified
+	<-counted
+}
+
+// Builds the server with its middleware and routes. Nothing here talks to
+// the database, that only happens as the requests come in.
+func newServer(cfg config, coll, hist, meta *mongo.Collection, counter *bookCounter) (*echo.Echo, *inFlight, *webhookNotifier, error) {
 	// Here we prepare the server
 	e := echo.New()
+	var err error
+
+	// Client addresses, as used in the logs and the audit trail, come from
+	// X-Forwarded-For only when it was set by one of our own proxies
+	e.IPExtractor = clientIPExtractor(cfg.TrustedProxies)
 
-	// Define our custom renderer
-	e.Renderer = loadTemplates()
+	// Define our custom renderer. Headless deployments serve the API only
+	// and do not need the views at all.
+	if !cfg.DisableHTML {
+		if e.Renderer, err = loadTemplates(); err != nil {
+			return nil, nil, nil, fmt.Errorf("could not load the views, set DISABLE_HTML=true to run without them: %v", err)
+		}
+	}
+
+	// Tag every request with an id first, so all log lines of a request,
+	// the access log included, can be correlated with the ones of the
+	// gateway in front of us.
+	e.Use(requestIDMiddleware(cfg.RequestIDHeader))
 
 	// Log the requests. Please have a look at echo's documentation on more
 	// middleware
-	e.Use(middleware.Logger())
+	e.Use(accessLogMiddleware())
+
+	requests := newInFlight()
+	e.Use(requests.middleware())
+
+	if len(cfg.Compression) > 0 {
+		e.Use(compressMiddleware(cfg.Compression, cfg.CompressionMinBytes))
+	}
+
+	e.Use(responseMiddleware(cfg.ResponseEnvelope, cfg.JSONCase, cfg.PrettyJSON))
+
+	// Let browsers and CDNs keep the answers of the read routes and
+	// revalidate them cheaply
+	e.Use(cacheMiddleware(cfg.BasePath, cfg.CacheMaxAge))
+
+	// Routes sent as POST that only read
+	readOnly := []string{
+		cfg.BasePath + "/api/books/query", cfg.BasePath + "/api/books/preview",
+		cfg.BasePath + "/api/books/lookup", cfg.BasePath + "/api/books/batch",
+		cfg.BasePath + "/api/books/diff",
+	}
+
+	// Integrations authenticate their changes with a static key
+	if len(cfg.APIKeys) > 0 {
+		e.Use(apiKeyMiddleware(cfg.APIKeys, cfg.AdminToken, cfg.BasePath+"/api", readOnly...))
+	}
 
-	e.Static("/css", "css")
+	// Anonymous readers share the server with everybody, keep any single
+	// one of them from flooding it
+	if cfg.PublicRateLimit > 0 {
+		e.Use(publicRateLimiter(cfg.PublicRateLimit, cfg.PublicRateBurst, cfg.APIKeys, cfg.AdminToken, cfg.BasePath+"/api", readOnly...))
+	}
+
+	// Queue requests during bursts rather than running out of connections
+	if cfg.DBMaxConcurrent > 0 {
+		limiter := newDBLimiter(cfg.DBMaxConcurrent, cfg.DBQueueTimeout)
+		e.Use(limiter.middleware(cfg.BasePath+coverURLPrefix, cfg.BasePath+"/metrics", cfg.BasePath+"/api/books/stream"))
+	}
+
+	// The filters of every page read the authors and years, which only
+	// change with the catalog
+	distinct := newReadCache(cfg.DistinctCacheTTL)
+	e.Use(distinct.invalidateOnWrite(cfg.BasePath+"/api", readOnly...))
+
+	e.Use(serverTimingMiddleware(cfg.BasePath + "/api"))
+
+	// Abort reads the database is still busy with after QUERY_MAX_TIME
+	e.Use(queryTimeMiddleware(cfg.QueryMaxTime))
+
+	// Dumping bodies is for debugging client integrations only: they may be
+	// large or contain data that should not end up in the logs.
+	if cfg.DebugBodies {
+		e.Use(bodyDumpMiddleware(cfg.DebugBodiesMax, cfg.BasePath+"/api", cfg.BasePath+"/api/books/stream"))
+	}
+
+	// Routes are registered without a trailing slash, which is the canonical
+	// form. Requests such as /api/books/ are rewritten before routing instead
+	// of redirected, so clients sending a POST or PUT do not lose their body.
+	e.Pre(middleware.RemoveTrailingSlash())
+
+	// Probes with absurd URIs or headers are turned away before routing
+	e.Pre(requestLimitsMiddleware(cfg.MaxURILength))
+	e.Server.MaxHeaderBytes = cfg.MaxHeaderBytes
+
+	// Every route lives below BASE_PATH, so several services can share one
+	// host name behind an ingress. Without it the group is the root.
+	r := e.Group(cfg.BasePath)
+
+	// Changes made below are posted to WEBHOOK_URL, if there is one. The
+	// notifier is nil otherwise and notifies nobody.
+	var webhooks *webhookNotifier
+	if cfg.WebhookURL != "" {
+		webhooks = newWebhookNotifier(cfg.WebhookURL, cfg.WebhookSecret, cfg.WebhookTimeout, cfg.WebhookRetries)
+	}
+
+	// How the ISBNs of the books saved below are stored
+	isbns := isbnConversion{convert: cfg.ConvertISBN10, keepOriginal: cfg.KeepOriginalISBN}
+
+	r.Static(coverURLPrefix, cfg.CoverDir)
 
 	// Endpoint definition. Here, we divided into two groups: top-level routes
 	// starting with /, which usually serve webpages. For our RESTful endpoints,
 	// we prefix the route with /api to indicate more information or resources
 	// are available under such route.
-	e.GET("/", func(c echo.Context) error {
-		return c.Render(200, "index", nil)
+	if !cfg.DisableHTML {
+		registerPages(r, coll, cfg.BasePath)
+	}
+
+	r.GET("/metrics", func(c echo.Context) error {
+		return c.String(200, counter.metrics())
 	})
 
-	e.GET("/books", func(c echo.Context) error {
-		books := findAllBooks(coll)
-		return c.Render(200, "book-table", books)
+	r.GET("/api/books", func(c echo.Context) error {
+		filter, err := bookFilterFromQuery(c)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		return respondBookPage(c, coll, filter, cfg.pageSize(c), cfg.MaxResults, cfg.DefaultSort)
 	})
 
-	e.GET("/authors", func(c echo.Context) error {
-		authors := findAllAuthors(coll)
-		return c.Render(200, "author-table", authors)
+	// Combined filters for power users, e.g. author contains X and year > 1900.
+	// Pagination works as for GET /api/books.
+	r.POST("/api/books/query", func(c echo.Context) error {
+		var query bookQuery
+		if err := c.Bind(&query); err != nil {
+			return respondError(c, 400, "Expected {\"filters\": [{\"field\": ..., \"op\": ..., \"value\": ...}]}")
+		}
+		filter, err := query.filter()
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		return respondBookPage(c, coll, filter, cfg.pageSize(c), cfg.MaxResults, cfg.DefaultSort)
 	})
 
-	e.GET("/years", func(c echo.Context) error {
-		years := findAllYears(coll)
-		return c.Render(200, "year-table", years)
+	// Full-text search over name, subtitle and author, ranked by relevance
+	r.GET("/api/books/search", func(c echo.Context) error {
+		query := strings.TrimSpace(c.QueryParam("q"))
+		if query == "" {
+			return respondError(c, 400, "Missing search query ?q=")
+		}
+		books, err := searchBooks(c.Request().Context(), coll, query, int64(cfg.MaxResults))
+		if err != nil {
+			return respondDBError(c, err, "Could not search the books")
+		}
+		if c.QueryParam("highlight") == "true" {
+			highlightBooks(books, query, cfg.HighlightPre, cfg.HighlightPost)
+		}
+		return respond(c, 200, books)
 	})
 
-	e.GET("/search", func(c echo.Context) error {
-		return c.Render(200, "search-bar", nil)
+	// The books at either end of the timeline, ranked by year and name
+	for path, newest := range map[string]bool{"/api/books/oldest": false, "/api/books/newest": true} {
+		newest := newest
+		r.GET(path, func(c echo.Context) error {
+			limit, err := parseLimit(c, 10, maxRankedBooks)
+			if err != nil {
+				return respondError(c, 400, err.Error())
+			}
+			books, err := rankBooksByYear(c.Request().Context(), coll, newest, int64(limit))
+			if err != nil {
+				return respondDBError(c, err, "Could not fetch the books")
+			}
+			return respond(c, 200, books)
+		})
+	}
+
+	// The books published around a year, closest first
+	r.GET("/api/books/near-year", func(c echo.Context) error {
+		year, err := strconv.Atoi(c.QueryParam("year"))
+		if err != nil {
+			return respondError(c, 400, fmt.Sprintf("invalid year %q", c.QueryParam("year")))
+		}
+		limit, err := parseLimit(c, 10, maxRankedBooks)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		books, err := findBooksNearYear(c.Request().Context(), coll, year, int64(limit))
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		return respond(c, 200, books)
 	})
 
-	e.GET("/create", func(c echo.Context) error {
-		return c.NoContent(304)
+	// Books added in a window, for acquisition reports. Paginated like the
+	// list, whose meta.total counts them; in the order they were added
+	// unless ?sort= says otherwise.
+	r.GET("/api/books/acquisitions", func(c echo.Context) error {
+		from, to, err := parseAcquisitionWindow(c.QueryParam("from"), c.QueryParam("to"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		added := bson.D{{Key: "_id", Value: 1}}
+		return respondBookPage(c, coll, acquisitionFilter(from, to), cfg.pageSize(c), cfg.MaxResults, added)
+	})
+
+	// Books of one publisher, or of a whole registration group, by the
+	// start of their ISBN-13. Paginated like the list.
+	r.GET("/api/books/by-publisher/:prefix", func(c echo.Context) error {
+		prefix, err := url.PathUnescape(c.Param("prefix"))
+		if err != nil {
+			return respondError(c, 400, "Invalid prefix")
+		}
+		filter, err := isbnPrefixFilter(prefix)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		return respondBookPage(c, coll, filter, cfg.pageSize(c), cfg.MaxResults, cfg.DefaultSort)
+	})
+
+	// Live updates. The streams end when the server shuts down, otherwise
+	// the shutdown would wait for them until its deadline.
+	streaming, stopStreaming := context.WithCancel(context.Background())
+	e.Server.RegisterOnShutdown(stopStreaming)
+	r.GET("/api/books/stream", func(c echo.Context) error {
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+		defer context.AfterFunc(streaming, cancel)()
+		return streamBooks(ctx, c, coll)
+	})
+
+	r.GET("/api/books/count", func(c echo.Context) error {
+		filter, err := bookFilterFromQuery(c)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		count, err := countBooks(c.Request().Context(), coll, filter)
+		if err != nil {
+			return respondDBError(c, err, "Could not count the books")
+		}
+		return respond(c, 200, fields{"count": count})
+	})
+
+	r.GET("/api/books/export.csv", func(c echo.Context) error {
+		filter, err := bookFilterFromQuery(c)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		delimiter, columns, err := parseCSVOptions(c)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		return writeCSV(c, coll, filter, delimiter, columns)
+	})
+
+	// The catalog in Dublin Core for library systems, filtered like the list
+	r.GET("/api/books/export.dc.xml", func(c echo.Context) error {
+		filter, err := bookFilterFromQuery(c)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		return writeDublinCore(c, coll, filter)
+	})
+
+	r.GET("/api/books/feed.xml", func(c echo.Context) error {
+		books, err := findRecentBooks(c.Request().Context(), coll, feedSize)
+		if err != nil {
+			return respondDBError(c, err, "Could not load the latest books")
+		}
+		// The Host header is whatever the client sent, so the link is
+		// only absolute when PUBLIC_URL says where the server is
+		feed, err := renderFeed(books, cfg.PublicURL+cfg.BasePath+"/")
+		if err != nil {
+			return respondDBError(c, err, "Could not render the feed")
+		}
+		return c.Blob(200, "application/rss+xml; charset=UTF-8", feed)
+	})
+
+	r.POST("/api/books/:id/cover", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		file, err := c.FormFile("cover")
+		if err != nil {
+			return respondError(c, 400, "Expected an image in the multipart field \"cover\"")
+		}
+		if file.Size > cfg.CoverMaxBytes {
+			return respondError(c, 413, fmt.Sprintf("Cover images may be at most %d bytes", cfg.CoverMaxBytes))
+		}
+		before, err := findBookByID(c.Request().Context(), coll, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		}
+		if err != nil {
+			return respondDBError(c, err, "Could not store the cover")
+		}
+		if lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+		book, err := storeCover(c.Request().Context(), coll, cfg.CoverDir, cfg.BasePath+coverURLPrefix, cfg.CoverMaxBytes, id, file)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		case errors.Is(err, errUnsupportedCover):
+			return respondError(c, 415, "Cover images must be JPEG or PNG")
+		case errors.Is(err, errCoverTooLarge):
+			return respondError(c, 413, fmt.Sprintf("Cover images may be at most %d bytes", cfg.CoverMaxBytes))
+		case err != nil:
+			return respondDBError(c, err, "Could not store the cover")
+		}
+		recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), &before, &book)
+		return respondChanged(c, 200, convertToBook(book), nil, nil)
 	})
 
-	e.GET("/api/books", func(c echo.Context) error {
-		books := getAllBooks(coll)
-		return c.JSON(200, books)
+	r.GET("/api/books/duplicates", func(c echo.Context) error {
+		groups, err := findDuplicateGroups(c.Request().Context(), coll)
+		if err != nil {
+			return respondDBError(c, err, "Could not search for duplicates")
+		}
+		return respond(c, 200, groups)
 	})
 
-	e.POST("/api/books", func(c echo.Context) error {
+	// A single book. Ids of deleted books are answered with 410 rather than
+	// 404, so clients can tell them from ids that never existed and drop
+	// what they cached.
+	r.GET("/api/books/:id", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		book, err := findBookByID(c.Request().Context(), coll, id)
+		if err == nil {
+			return respond(c, 200, convertToBook(book))
+		} else if err != mongo.ErrNoDocuments {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		deletedAt, err := findDeletion(c.Request().Context(), hist, id)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		return respondProblem(c, 410, newProblem("book_gone", deletedAt.Format(time.RFC3339)))
+	})
+
+	// The books before and after a book in the order of ?sort=, for
+	// previous and next links. Without ?sort= the order of the lists is used.
+	r.GET("/api/books/:id/neighbors", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		sort := cfg.DefaultSort
+		if value := c.QueryParam("sort"); value != "" {
+			if sort, err = parseSort(value); err != nil {
+				return respondError(c, 400, err.Error())
+			}
+		}
+		// The list puts the books without a position last, which a single
+		// range query per neighbor cannot follow across
+		if sortsByDisplayOrder(sort) {
+			return respondError(c, 400, "Neighbors cannot be found in the display order, sort by another field")
+		}
+		neighbors, err := findNeighbors(c.Request().Context(), coll, id, sort)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		return respond(c, 200, neighbors)
+	})
+
+	r.GET("/api/books/:id/history", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		history, err := findHistory(c.Request().Context(), hist, id)
+		if err != nil {
+			return respondDBError(c, err, "Could not load the history")
+		}
+		return respond(c, 200, history)
+	})
+
+	// "You might also like": books sharing the author, decade or tags
+	r.GET("/api/books/:id/similar", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		limit, err := parseLimit(c, 5, maxSimilarBooks)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		book, err := findBookByID(c.Request().Context(), coll, id)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		similar, err := findSimilarBooks(c.Request().Context(), coll, book, limit)
+		if err != nil {
+			return respondDBError(c, err, "Could not find similar books")
+		}
+		return respond(c, 200, similar)
+	})
+
+	// The book as a reference for a bibliography, plain text unless the
+	// client accepts JSON
+	r.GET("/api/books/:id/citation", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		style := c.QueryParam("style")
+		if style == "" {
+			style = citationAPA
+		}
+		if !validCitationStyle(style) {
+			return respondError(c, 400, fmt.Sprintf("Unknown citation style %q, expected apa, mla or chicago", style))
+		}
+		book, err := findBookByID(c.Request().Context(), coll, id)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		citation := citeBook(book, style)
+		if acceptsJSON(c) {
+			return respond(c, 200, bookCitation{Style: style, Citation: citation})
+		}
+		return c.String(200, citation)
+	})
+
+	r.GET("/api/authors/top", func(c echo.Context) error {
+		limit, err := parseLimit(c, 5, maxTopAuthors)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		authors, err := distinct.get(c.Request().Context(), "authors/top/"+strconv.Itoa(limit), func(ctx context.Context) (interface{}, error) {
+			return topAuthors(ctx, coll, limit)
+		})
+		if err != nil {
+			return respondDBError(c, err, "Could not compute the statistics")
+		}
+		return respond(c, 200, authors)
+	})
+
+	r.GET("/api/authors/:author/books", func(c echo.Context) error {
+		author := normalizeAuthor(c.Param("author"))
+		if author == "" {
+			return respondError(c, 400, "Missing author")
+		}
+		books, err := findBooksByAuthor(c.Request().Context(), coll, author)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		return respond(c, 200, books)
+	})
+
+	r.GET("/api/books/grouped-by-author", func(c echo.Context) error {
+		groups, err := groupBooksByAuthor(c.Request().Context(), coll, int64(cfg.MaxResults)+1)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		total := 0
+		for _, group := range groups {
+			total += len(group.Books)
+		}
+		if total > cfg.MaxResults {
+			return respondError(c, 400, fmt.Sprintf("More than %d books, please use /api/authors/:author/books instead", cfg.MaxResults))
+		}
+		return respond(c, 200, groups)
+	})
+
+	// Document count, index status and version of the database for the ops
+	// dashboard
+	r.GET("/api/admin/health", func(c echo.Context) error {
+		if !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "The health report requires admin privileges")
+		}
+		report := checkHealth(c.Request().Context(), coll, hist, meta, cfg.RequireSchema)
+		if report.Status == "unavailable" {
+			logger.ErrorContext(c.Request().Context(), "health check failed", "error", report.Error)
+			return respond(c, 503, report)
+		}
+		return respond(c, 200, report)
+	})
+
+	// Reports the ISBNs that would keep a unique index on the ISBN from
+	// being created. Read only.
+	r.GET("/api/admin/check-isbn-uniqueness", func(c echo.Context) error {
+		if !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "Checking the ISBNs requires admin privileges")
+		}
+		report, err := checkISBNUniqueness(c.Request().Context(), coll)
+		if err != nil {
+			return respondDBError(c, err, "Could not check the ISBNs")
+		}
+		return respond(c, 200, report)
+	})
+
+	// Books whose ISBN is malformed or has a wrong check digit, for the
+	// cleanup queue. Read only.
+	r.GET("/api/admin/invalid-isbns", func(c echo.Context) error {
+		if !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "Checking the ISBNs requires admin privileges")
+		}
+		report, err := findInvalidISBNs(c.Request().Context(), coll, cfg.MaxResults)
+		if err != nil {
+			return respondDBError(c, err, "Could not check the ISBNs")
+		}
+		return respond(c, 200, report)
+	})
+
+	r.GET("/api/admin/schema-version", func(c echo.Context) error {
+		if !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "The schema version requires admin privileges")
+		}
+		status, err := readSchemaStatus(c.Request().Context(), meta)
+		if err != nil {
+			return respondDBError(c, err, "Could not read the schema version")
+		}
+		return respond(c, 200, status)
+	})
+
+	// Backfills documents written by older versions. Safe to run again.
+	r.POST("/api/admin/migrate", func(c echo.Context) error {
+		if !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "Migrations require admin privileges")
+		}
+		report, err := migrateSchema(c.Request().Context(), coll, meta)
+		if err != nil {
+			logger.ErrorContext(c.Request().Context(), "migration failed", "error", err)
+			return respondError(c, 500, fmt.Sprintf("The migration stopped early after %d steps, it can be run again", len(report.Steps)-1))
+		}
+		return respond(c, 200, report)
+	})
+
+	// Rewrites author names into one order, e.g. "Shelley, Mary" into
+	// "Mary Shelley". Without dryRun=false it only shows what would change.
+	r.POST("/api/admin/authors/normalize", func(c echo.Context) error {
+		if !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "Normalizing authors requires admin privileges")
+		}
+		convention := c.QueryParam("convention")
+		if convention != conventionFirstLast && convention != conventionLastFirst {
+			return respondError(c, 400, fmt.Sprintf("convention must be %s or %s", conventionFirstLast, conventionLastFirst))
+		}
+		dryRun := c.QueryParam("dryRun") != "false"
+		report, err := normalizeAuthorNames(c.Request().Context(), coll, hist, webhooks, requestActor(c), convention, dryRun)
+		if err != nil {
+			logger.ErrorContext(c.Request().Context(), "normalizing authors failed", "updated", report.Updated, "error", err)
+			return respondError(c, 500, fmt.Sprintf("Stopped after renaming %d books, it can be run again", report.Updated))
+		}
+		return respond(c, 200, report)
+	})
+
+	// Lets forms check an ISBN while it is typed, with the same rules the
+	// save path applies
+	r.GET("/api/isbn/validate", func(c echo.Context) error {
+		isbn := c.QueryParam("isbn")
+		if strings.TrimSpace(isbn) == "" {
+			return respondError(c, 400, "Missing ISBN ?isbn=")
+		}
+		return respond(c, 200, describeISBN(isbn))
+	})
+
+	r.GET("/api/years", func(c echo.Context) error {
+		years, err := distinct.get(c.Request().Context(), "years", func(ctx context.Context) (interface{}, error) {
+			return findDistinctYears(ctx, coll)
+		})
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the years")
+		}
+		return respond(c, 200, years)
+	})
+
+	r.GET("/api/stats/pages-by-author", func(c echo.Context) error {
+		stats, err := pagesByAuthor(c.Request().Context(), coll)
+		if err != nil {
+			return respondDBError(c, err, "Could not compute the statistics")
+		}
+		return respond(c, 200, stats)
+	})
+
+	r.GET("/api/stats/author-decade", func(c echo.Context) error {
+		stats, err := booksPerInitialAndDecade(c.Request().Context(), coll)
+		if err != nil {
+			return respondDBError(c, err, "Could not compute the statistics")
+		}
+		return respond(c, 200, stats)
+	})
+
+	// Share of the books that have each optional field filled in
+	r.GET("/api/stats/completeness", func(c echo.Context) error {
+		report, err := catalogCompleteness(c.Request().Context(), coll)
+		if err != nil {
+			return respondDBError(c, err, "Could not compute the statistics")
+		}
+		return respond(c, 200, report)
+	})
+
+	// Books by the registration group of their ISBN, a rough breakdown by
+	// language area and country
+	r.GET("/api/stats/isbn-prefix", func(c echo.Context) error {
+		limit, err := parseLimit(c, 10, maxISBNPrefixes)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		report, err := booksPerISBNPrefix(c.Request().Context(), coll, limit)
+		if err != nil {
+			return respondDBError(c, err, "Could not compute the statistics")
+		}
+		return respond(c, 200, report)
+	})
+
+	r.GET("/api/stats/histogram", func(c echo.Context) error {
+		var bounds [2]*int
+		for i, name := range []string{"from", "to"} {
+			if value := c.QueryParam(name); value != "" {
+				year, err := strconv.Atoi(value)
+				if err != nil {
+					return respondError(c, 400, fmt.Sprintf("invalid %s year %q", name, value))
+				}
+				bounds[i] = &year
+			}
+		}
+		if bounds[0] != nil && bounds[1] != nil && *bounds[0] > *bounds[1] {
+			return respondError(c, 400, "from must not be after to")
+		}
+		histogram, err := booksPerYear(c.Request().Context(), coll, bounds[0], bounds[1])
+		if errors.Is(err, errHistogramTooWide) {
+			return respondError(c, 400, err.Error())
+		}
+		if err != nil {
+			return respondDBError(c, err, "Could not compute the histogram")
+		}
+		return respond(c, 200, histogram)
+	})
+
+	r.POST("/api/books", func(c echo.Context) error {
 		var book Book
-		c.Bind(&book)
-		toPost := convertToBookstore(book)
-		if checkIfDuplicateExists(coll, toPost) {
-			return c.JSON(304, "Duplicate not allowed")
+		invalid, err := bindBook(c, &book, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondBindingError(c, err)
+		}
+		problems, warnings := checkBook(book, strictValidation(c))
+		if len(problems) > 0 {
+			return respondInvalid(c, problems)
+		}
+		toPost := convertToBookstore(book, isbns)
+		// Trusted bulk loaders that already deduplicated their data may
+		// skip the lookup; the book is then inserted as it is, duplicate
+		// or not. The server creates no unique index on the ISBN. One
+		// made by hand, once GET /api/admin/check-isbn-uniqueness finds
+		// no conflicts, is answered with 409 below.
+		skipCheck := c.QueryParam("skipDuplicateCheck") == "true"
+		if skipCheck && !isAdmin(c, cfg.AdminToken) {
+			return respondError(c, 403, "skipDuplicateCheck requires admin privileges")
+		}
+		if !skipCheck && checkIfDuplicateExists(c.Request().Context(), coll, toPost) {
+			return respond(c, 304, "Duplicate not allowed")
+		}
+		if !counter.allows(1, cfg.MaxBooks) {
+			return respondQuotaExceeded(c, cfg.MaxBooks)
+		}
+		id, err := saveBook(c.Request().Context(), coll, toPost)
+		if mongo.IsDuplicateKeyError(err) {
+			return respondProblem(c, 409, newProblem("duplicate_book"))
+		} else if err != nil {
+			logger.ErrorContext(c.Request().Context(), "could not save book", "error", err)
+			return respondError(c, 500, "Could not save the book")
 		}
-		res := saveBook(coll, toPost)
-		return c.JSON(200, res)
+		counter.add(1)
+		if created, err := findBookByID(c.Request().Context(), coll, id); err == nil {
+			recordAudit(c.Request().Context(), hist, webhooks, actionCreated, requestActor(c), nil, &created)
+		}
+		res := fields{"id": id}
+		if len(warnings) > 0 {
+			res["warnings"] = messages(warnings, language(c))
+		}
+		bookLocation(c, cfg.BasePath, id)
+		return respondChanged(c, 200, []fields{res}, nil, nil)
 	})
 
-	e.PUT("/api/books", func(c echo.Context) error {
+	// Inserts the book, or updates the one with the same ISBN if there is
+	// one, for importers that sync by ISBN rather than checking for
+	// duplicates themselves
+	r.POST("/api/books/upsert", func(c echo.Context) error {
 		var book Book
-		c.Bind(&book)
-		toUpdate := convertToBookstore(book)
-		if checkIfDuplicateExists(coll, toUpdate) {
-			return c.JSON(201, "Duplicate not allowed")
+		invalid, err := bindBook(c, &book, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondBindingError(c, err)
+		}
+		book.ID = ""
+		problems, warnings := checkBook(book, strictValidation(c))
+		if normalizeISBN(book.ISBN) == "" {
+			problems = append(problems, newProblem("isbn_required"))
+		}
+		if len(problems) > 0 {
+			return respondInvalid(c, problems)
+		}
+		matches, err := findBooksByISBN(c.Request().Context(), coll, []string{book.ISBN})
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		if len(matches) > 1 {
+			return respondProblem(c, 409, newProblem("isbn_ambiguous", len(matches), book.ISBN))
+		}
+		var before *BookStore
+		if len(matches) == 1 {
+			id, _ := primitive.ObjectIDFromHex(matches[0].ID)
+			found, err := findBookByID(c.Request().Context(), coll, id)
+			if err != nil {
+				return respondDBError(c, err, "Could not fetch the book")
+			}
+			if lockedByOther(found, cfg.lockOwner(c)) {
+				return respondLocked(c, found.Lock)
+			}
+			before = &found
+		} else if !counter.allows(1, cfg.MaxBooks) {
+			return respondQuotaExceeded(c, cfg.MaxBooks)
+		}
+		id, created, err := upsertBook(c.Request().Context(), coll, before, convertToBookstore(book, isbns))
+		if err != nil {
+			logger.ErrorContext(c.Request().Context(), "could not upsert book", "error", err)
+			return respondError(c, 500, "Could not save the book")
+		}
+		if created {
+			counter.add(1)
+		}
+		if after, err := findBookByID(c.Request().Context(), coll, id); err == nil {
+			if created {
+				recordAudit(c.Request().Context(), hist, webhooks, actionCreated, requestActor(c), nil, &after)
+			} else {
+				recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), before, &after)
+			}
 		}
-		updateBook(coll, toUpdate)
-		return c.JSON(200, "Updated the book")
+		status := 200
+		if created {
+			status = 201
+			bookLocation(c, cfg.BasePath, id)
+		}
+		return respondChanged(c, status, upsertResult{ID: id.Hex(), Created: created}, nil, warningsMeta(c, warnings))
 	})
 
-	e.DELETE("/api/books/:id", func(c echo.Context) error {
-		id := c.Param("id")
-		objectId, _ := primitive.ObjectIDFromHex(id)
-		deleteBook(coll, objectId)
-		return c.JSON(200, "Succesfully deleted entry")
+	// Renders a book the way the table on the page would show it, without
+	// storing it. The view escapes the submitted text like any stored book.
+	if !cfg.DisableHTML {
+		r.POST("/api/books/preview", func(c echo.Context) error {
+			var book Book
+			invalid, err := bindBook(c, &book, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+			if len(invalid) > 0 {
+				return respondBindingProblems(c, invalid)
+			} else if err != nil {
+				return respondBindingError(c, err)
+			}
+			book.ID = "preview"
+			return c.Render(200, "book-row", book)
+		})
+	}
+
+	r.POST("/api/books/import", func(c echo.Context) error {
+		var books []Book
+		invalid, err := bindBook(c, &books, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondError(c, 400, "Expected a JSON array of books")
+		}
+		if len(books) > maxImportRows {
+			return respondError(c, 413, fmt.Sprintf("At most %d books can be imported at once", maxImportRows))
+		}
+		report, planned := planImport(c.Request().Context(), coll, books, isbns, language(c), strictValidation(c))
+		report.DryRun = c.QueryParam("dryRun") == "true"
+		if !counter.allows(len(planned), cfg.MaxBooks) {
+			return respondQuotaExceeded(c, cfg.MaxBooks)
+		}
+		if !report.DryRun {
+			err := runImport(c.Request().Context(), coll, hist, webhooks, requestActor(c), language(c), &report, planned)
+			counter.add(int64(report.Inserted))
+			if err != nil {
+				return respondDBError(c, err, "Could not import the books")
+			}
+		}
+		return respond(c, 200, report)
+	})
+
+	// Imports the books of a .bib file, sent in the multipart field "file"
+	// or as the body. Works like /api/books/import, one row per entry.
+	r.POST("/api/books/import/bibtex", func(c echo.Context) error {
+		var src io.Reader = c.Request().Body
+		if file, err := c.FormFile("file"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return respondError(c, 400, "Could not read the uploaded file")
+			}
+			defer f.Close()
+			src = f
+		}
+		data, err := io.ReadAll(io.LimitReader(src, maxBibTeXBytes+1))
+		if err != nil {
+			return respondError(c, 400, "Could not read the uploaded file")
+		}
+		if len(data) > maxBibTeXBytes {
+			return respondError(c, 413, fmt.Sprintf("BibTeX files may be at most %d bytes", maxBibTeXBytes))
+		}
+		entries := parseBibTeX(bibText(data))
+		if len(entries) == 0 {
+			return respondError(c, 400, "Expected a BibTeX file with at least one entry")
+		}
+		if len(entries) > maxImportRows {
+			return respondError(c, 413, fmt.Sprintf("At most %d books can be imported at once", maxImportRows))
+		}
+		report, planned := planBibTeXImport(c.Request().Context(), coll, entries, isbns, language(c), strictValidation(c))
+		report.DryRun = c.QueryParam("dryRun") == "true"
+		if !counter.allows(len(planned), cfg.MaxBooks) {
+			return respondQuotaExceeded(c, cfg.MaxBooks)
+		}
+		if !report.DryRun {
+			err := runImport(c.Request().Context(), coll, hist, webhooks, requestActor(c), language(c), &report, planned)
+			counter.add(int64(report.Inserted))
+			if err != nil {
+				return respondDBError(c, err, "Could not import the books")
+			}
+		}
+		return respond(c, 200, report)
+	})
+
+	// Compares a snapshot of the catalog, as another system has it, with the
+	// catalog. Read only.
+	r.POST("/api/books/diff", func(c echo.Context) error {
+		var books []Book
+		invalid, err := bindBook(c, &books, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondError(c, 400, "Expected a JSON array of books")
+		}
+		if len(books) > maxDiffBooks {
+			return respondError(c, 413, fmt.Sprintf("At most %d books can be compared at once", maxDiffBooks))
+		}
+		diff, err := diffSnapshot(c.Request().Context(), coll, books, cfg.MaxResults)
+		if err != nil {
+			return respondDBError(c, err, "Could not compare the books")
+		}
+		return respond(c, 200, diff)
+	})
+
+	r.POST("/api/books/lookup", func(c echo.Context) error {
+		var req struct {
+			ISBNs []string `json:"isbns"`
+		}
+		if err := c.Bind(&req); err != nil || len(req.ISBNs) == 0 {
+			return respondError(c, 400, "Expected {\"isbns\": [...]}")
+		}
+		if len(req.ISBNs) > maxLookupISBNs {
+			return respondError(c, 400, fmt.Sprintf("At most %d ISBNs can be looked up at once", maxLookupISBNs))
+		}
+		books, err := findBooksByISBN(c.Request().Context(), coll, req.ISBNs)
+		if err != nil {
+			return respondDBError(c, err, "Could not look up the books")
+		}
+		return respond(c, 200, books)
+	})
+
+	r.POST("/api/books/batch", func(c echo.Context) error {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := c.Bind(&req); err != nil || len(req.IDs) == 0 {
+			return respondError(c, 400, "Expected {\"ids\": [...]}")
+		}
+		if len(req.IDs) > maxBatchIDs {
+			return respondError(c, 400, fmt.Sprintf("At most %d books can be fetched at once", maxBatchIDs))
+		}
+		batch, err := findBooksByIDs(c.Request().Context(), coll, req.IDs)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		return respond(c, 200, batch)
+	})
+
+	r.PUT("/api/books", func(c echo.Context) error {
+		var book Book
+		invalid, err := bindBook(c, &book, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondBindingError(c, err)
+		}
+		problems, warnings := checkBook(book, strictValidation(c))
+		if len(problems) > 0 {
+			return respondInvalid(c, problems)
+		}
+		if _, err := parseBookID(book.ID); err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		toUpdate := convertToBookstore(book, isbns)
+		if checkIfDuplicateExists(c.Request().Context(), coll, toUpdate) {
+			return respond(c, 201, "Duplicate not allowed")
+		}
+		before, err := findBookByID(c.Request().Context(), coll, toUpdate.ID)
+		if err == nil && lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+		updateBook(c.Request().Context(), coll, toUpdate)
+		if err == nil {
+			if after, err := findBookByID(c.Request().Context(), coll, toUpdate.ID); err == nil {
+				recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), &before, &after)
+			}
+		}
+		return respondChanged(c, 200, "Updated the book", nil, warningsMeta(c, warnings))
+	})
+
+	// Updates the book with the given ISBN, for integrations that know the
+	// ISBN but not our id. Like PUT /api/books, all fields are replaced,
+	// except that a missing ISBN keeps the stored one.
+	r.PUT("/api/books/by-isbn/:isbn", func(c echo.Context) error {
+		isbn, err := url.PathUnescape(c.Param("isbn"))
+		if err != nil || normalizeISBN(isbn) == "" {
+			return respondError(c, 400, "Invalid ISBN")
+		}
+		var book Book
+		invalid, err := bindBook(c, &book, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondBindingError(c, err)
+		}
+		matches, err := findBooksByISBN(c.Request().Context(), coll, []string{isbn})
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		switch {
+		case len(matches) == 0:
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		case len(matches) > 1:
+			return respondProblem(c, 409, newProblem("isbn_ambiguous", len(matches), isbn))
+		}
+		book.ID = matches[0].ID
+		if book.ISBN == "" {
+			book.ISBN = matches[0].ISBN
+		}
+		problems, warnings := checkBook(book, strictValidation(c))
+		if len(problems) > 0 {
+			return respondInvalid(c, problems)
+		}
+		toUpdate := convertToBookstore(book, isbns)
+		if checkIfDuplicateExists(c.Request().Context(), coll, toUpdate) {
+			return respondProblem(c, 409, newProblem("duplicate_book"))
+		}
+		before, err := findBookByID(c.Request().Context(), coll, toUpdate.ID)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		if lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+		updateBook(c.Request().Context(), coll, toUpdate)
+		after, err := findBookByID(c.Request().Context(), coll, toUpdate.ID)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the updated book")
+		}
+		recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), &before, &after)
+		return respondChanged(c, 200, convertToBook(after), nil, warningsMeta(c, warnings))
+	})
+
+	// Editors lock a book while they work on it, so others are told who is
+	// editing instead of overwriting each other. The lock expires after
+	// LOCK_TTL unless it is taken again, which extends it.
+	r.POST("/api/books/:id/lock", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		owner := cfg.lockOwner(c)
+		if owner == "" {
+			return respondProblem(c, 401, newProblem("lock_unauthorized"))
+		}
+		book, err := lockBook(c.Request().Context(), coll, id, owner, cfg.LockTTL)
+		switch {
+		case errors.Is(err, errLocked):
+			return respondLocked(c, book.Lock)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		case err != nil:
+			return respondDBError(c, err, "Could not lock the book")
+		}
+		return respondChanged(c, 200, book.Lock, nil, nil)
+	})
+
+	r.POST("/api/books/:id/tags", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.Bind(&req); err != nil || len(req.Tags) == 0 {
+			return respondError(c, 400, `Expected {"tags": [...]}`)
+		}
+		var problems []problem
+		for i, tag := range req.Tags {
+			req.Tags[i] = normalizeTag(tag)
+			problems = append(problems, validateTag(req.Tags[i])...)
+		}
+		if len(problems) > 0 {
+			return respondProblem(c, 422, newProblem("invalid_tags"), problems...)
+		}
+		before, err := findBookByID(c.Request().Context(), coll, id)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		if lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+		added := newTags(before, req.Tags)
+		if len(added) == 0 {
+			return respondChanged(c, 200, convertToBook(before), nil, nil)
+		}
+		if len(before.BookTags)+len(added) > maxTagsPerBook {
+			return respondProblem(c, 422, newProblem("invalid_tags"), newProblem("too_many_tags", maxTagsPerBook))
+		}
+		after, err := addTags(c.Request().Context(), coll, id, added)
+		if err != nil {
+			return respondDBError(c, err, "Could not tag the book")
+		}
+		recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), &before, &after)
+		return respondChanged(c, 200, convertToBook(after), nil, nil)
+	})
+
+	r.DELETE("/api/books/:id/tags/:tag", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		tag, err := url.PathUnescape(c.Param("tag"))
+		if err != nil {
+			return respondError(c, 400, "Invalid tag")
+		}
+		tag = normalizeTag(tag)
+		before, err := findBookByID(c.Request().Context(), coll, id)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		if lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+		// Removing a tag the book does not have changes nothing
+		if !slices.Contains(before.BookTags, tag) {
+			return respondChanged(c, 200, convertToBook(before), nil, nil)
+		}
+		after, err := removeTag(c.Request().Context(), coll, id, tag)
+		if err != nil {
+			return respondDBError(c, err, "Could not untag the book")
+		}
+		recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), &before, &after)
+		return respondChanged(c, 200, convertToBook(after), nil, nil)
+	})
+
+	r.DELETE("/api/books/:id/lock", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		owner := cfg.lockOwner(c)
+		if owner == "" {
+			return respondProblem(c, 401, newProblem("lock_unauthorized"))
+		}
+		book, err := unlockBook(c.Request().Context(), coll, id, owner)
+		switch {
+		case errors.Is(err, errLocked):
+			return respondLocked(c, book.Lock)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		case err != nil:
+			return respondDBError(c, err, "Could not unlock the book")
+		}
+		return c.NoContent(204)
+	})
+
+	r.PATCH("/api/books/:id", func(c echo.Context) error {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		var patch bookPatch
+		invalid, err := bindBook(c, &patch, strictJSON(c, cfg.StrictJSON), cfg.FieldAliases)
+		if len(invalid) > 0 {
+			return respondBindingProblems(c, invalid)
+		} else if err != nil {
+			return respondError(c, 400, "Expected a JSON object with the fields to change")
+		}
+		before, err := findBookByID(c.Request().Context(), coll, id)
+		if err == mongo.ErrNoDocuments {
+			return respondProblem(c, 404, newProblem("book_not_found"))
+		} else if err != nil {
+			return respondDBError(c, err, "Could not fetch the book")
+		}
+		if lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+
+		patched := patch.apply(before, isbns)
+		changed := changedFields(convertToBook(before), convertToBook(patched))
+		after := before
+		var warnings []problem
+		if len(changed) > 0 {
+			var problems []problem
+			problems, warnings = checkBook(convertToBook(patched), strictValidation(c))
+			if len(problems) > 0 {
+				return respondInvalid(c, problems)
+			}
+			if checkIfDuplicateExists(c.Request().Context(), coll, patched) {
+				return respondProblem(c, 409, newProblem("duplicate_book"))
+			}
+			if after, err = patchBook(c.Request().Context(), coll, id, patched); err != nil {
+				return respondDBError(c, err, "Could not update the book")
+			}
+			recordAudit(c.Request().Context(), hist, webhooks, actionUpdated, requestActor(c), &before, &after)
+		}
+
+		// Minimal answers still carry what changed and the new version
+		changed["id"] = after.ID.Hex()
+		changed["version"] = after.Version
+		return respondChanged(c, 200, convertToBook(after), changed, warningsMeta(c, warnings))
+	})
+
+	// Deletes several books at once and reports for every id whether it was
+	// deleted, not found, invalid or locked by somebody else
+	r.POST("/api/books/bulk-delete", func(c echo.Context) error {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := c.Bind(&req); err != nil || len(req.IDs) == 0 {
+			return respondError(c, 400, "Expected {\"ids\": [...]}")
+		}
+		if len(req.IDs) > maxBatchIDs {
+			return respondError(c, 400, fmt.Sprintf("At most %d books can be deleted at once", maxBatchIDs))
+		}
+		results, deleted, err := bulkDeleteBooks(c.Request().Context(), coll, hist, webhooks, requestActor(c), cfg.lockOwner(c), req.IDs)
+		if err != nil {
+			logger.ErrorContext(c.Request().Context(), "bulk delete failed", "error", err)
+			return respondError(c, 500, "Could not delete the books")
+		}
+		counter.add(-deleted)
+		return respondMeta(c, 200, results, map[string]interface{}{"deleted": deleted})
+	})
+
+	// Tags every book matching the filters of a query, e.g. all books of an
+	// author as "featured". A query without filters is refused, so the whole
+	// catalog is not tagged by accident.
+	r.POST("/api/books/bulk-tag", func(c echo.Context) error {
+		var req struct {
+			bookQuery
+			Tag string `json:"tag"`
+		}
+		if err := c.Bind(&req); err != nil || req.Tag == "" {
+			return respondError(c, 400, "Expected {\"filters\": [...], \"tag\": ...}")
+		}
+		if len(req.Filters) == 0 {
+			return respondError(c, 400, "At least one filter is required, the whole catalog cannot be tagged at once")
+		}
+		filter, err := req.filter()
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		tag := normalizeTag(req.Tag)
+		if problems := validateTag(tag); len(problems) > 0 {
+			return respondProblem(c, 422, newProblem("invalid_tags"), problems...)
+		}
+		report, err := bulkTagBooks(c.Request().Context(), coll, hist, webhooks, requestActor(c), cfg.lockOwner(c), filter, tag)
+		if errors.Is(err, errTooManyToTag) {
+			return respondError(c, 413, err.Error())
+		} else if err != nil {
+			logger.ErrorContext(c.Request().Context(), "bulk tag failed", "error", err)
+			return respondDBError(c, err, "Could not tag the books")
+		}
+		return respond(c, 200, report)
+	})
+
+	// Replaces the curated order of the featured shelf with the listed
+	// books, see ?sort=displayOrder
+	r.PUT("/api/books/order", func(c echo.Context) error {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := c.Bind(&req); err != nil || req.IDs == nil {
+			return respondError(c, 400, "Expected {\"ids\": [...]}")
+		}
+		if len(req.IDs) > cfg.MaxResults {
+			return respondError(c, 400, fmt.Sprintf("At most %d books can be ordered", cfg.MaxResults))
+		}
+		ids, err := parseDisplayOrder(req.IDs)
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		changed, missing, err := displayOrderChanges(c.Request().Context(), coll, ids)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		if len(missing) > 0 {
+			return respondProblem(c, 404, newProblem("books_not_found", strings.Join(missing, ", ")))
+		}
+		// The order is all or nothing, so a single locked book that would
+		// move refuses it
+		for _, book := range changed {
+			if lockedByOther(book, cfg.lockOwner(c)) {
+				return respondLocked(c, book.Lock)
+			}
+		}
+		if err := setDisplayOrder(c.Request().Context(), coll, hist, webhooks, requestActor(c), ids, changed); err != nil {
+			logger.ErrorContext(c.Request().Context(), "display order failed", "error", err)
+			return respondDBError(c, err, "Could not update the display order")
+		}
+		return respondChanged(c, 200, "Updated the display order", nil, nil)
+	})
+
+	r.DELETE("/api/books/:id", func(c echo.Context) error {
+		objectId, err := parseBookID(c.Param("id"))
+		if err != nil {
+			return respondError(c, 400, err.Error())
+		}
+		before, err := findBookByID(c.Request().Context(), coll, objectId)
+		if err == nil && lockedByOther(before, cfg.lockOwner(c)) {
+			return respondLocked(c, before.Lock)
+		}
+		deleteBook(c.Request().Context(), coll, objectId)
+		if err == nil {
+			counter.add(-1)
+			recordAudit(c.Request().Context(), hist, webhooks, actionDeleted, requestActor(c), &before, nil)
+		}
+		return respondChanged(c, 200, "Succesfully deleted entry", nil, nil)
 	})
 
-	e.Logger.Fatal(e.Start(":3030"))
+	return e, requests, webhooks, nil
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestDuplicateFilter(t *testing.T) {
+	stored, _ := primitive.ObjectIDFromHex("6acfbdef17d728e2108ee8f8")
+	isbnMatch := func(isbns ...string) bson.M {
+		return bson.M{"$in": bson.A{normalizedISBNExpr, isbns}}
+	}
+	noEdition := bson.M{"$in": bson.A{nil, ""}}
+
+	tests := []struct {
+		name string
+		book BookStore
+		want bson.M
+	}{
+		{
+			name: "ISBN alone decides",
+			book: BookStore{BookName: "Frankenstein", BookAuthor: "Mary Shelley", BookYear: 1818, BookISBN: "978-0-306-40615-7"},
+			want: bson.M{"$expr": isbnMatch("9780306406157", "0306406152"), "bookedition": noEdition},
+		},
+		{
+			name: "ISBN-10 matches its ISBN-13",
+			book: BookStore{BookISBN: "0-306-40615-2"},
+			want: bson.M{"$expr": isbnMatch("9780306406157", "0306406152"), "bookedition": noEdition},
+		},
+		{
+			name: "an ISBN prefix is no part of the ISBN",
+			book: BookStore{BookISBN: "ISBN: 978-0-306-40615-7"},
+			want: bson.M{"$expr": isbnMatch("9780306406157", "0306406152"), "bookedition": noEdition},
+		},
+		{
+			name: "invalid ISBNs are compared as they are",
+			book: BookStore{BookISBN: "12-34"},
+			want: bson.M{"$expr": isbnMatch("1234"), "bookedition": noEdition},
+		},
+		{
+			name: "no ISBN falls back to name, author and year",
+			book: BookStore{BookName: "The Vortex", BookAuthor: "José Eustasio Rivera", BookYear: 1924},
+			want: bson.M{"bookname": "The Vortex", "bookauthor": "José Eustasio Rivera", "bookyear": 1924, "bookedition": noEdition},
+		},
+		{
+			name: "blank ISBN counts as none",
+			book: BookStore{BookName: "The Vortex", BookAuthor: "José Eustasio Rivera", BookISBN: " - "},
+			want: bson.M{"bookname": "The Vortex", "bookauthor": "José Eustasio Rivera", "bookyear": 0, "bookedition": noEdition},
+		},
+		{
+			name: "editions are separate books",
+			book: BookStore{BookISBN: "9780306406157", BookEdition: "2nd"},
+			want: bson.M{"$expr": isbnMatch("9780306406157", "0306406152"), "bookedition": "2nd"},
+		},
+		{
+			name: "a stored book is not its own duplicate",
+			book: BookStore{ID: stored, BookName: "The Vortex", BookAuthor: "José Eustasio Rivera", BookYear: 1924},
+			want: bson.M{"bookname": "The Vortex", "bookauthor": "José Eustasio Rivera", "bookyear": 1924, "bookedition": noEdition, "_id": bson.M{"$ne": stored}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := duplicateFilter(test.book); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// A server whose database is never reached. The driver only dials once an
+// operation runs, so requests that touch it fail rather than block for
+// long, and the ones answered before that behave as in production.
+func newTestServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DisableHTML = true
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	coll := client.Database("test").Collection("books")
+	e, _, _, err := newServer(cfg, coll, historyCollection(coll), metaCollection(coll), &bookCounter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// Malformed ids are answered with 400 before the database is asked, rather
+// than becoming the zero id and a 500 or a change of the wrong book
+func TestMalformedBookIDs(t *testing.T) {
+	e := newTestServer(t)
+	ids := map[string]string{
+		"too short": "6acfbdef17d7",
+		"too long":  "6acfbdef17d728e2108ee8f8f8",
+		"not hex":   "6acfbdef17d728e2108eezzz",
+		"escaped":   "6acfbdef17d728e2108ee8f%2F",
+	}
+	routes := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/api/books/%s", ""},
+		{http.MethodPatch, "/api/books/%s", `{"pages": 10}`},
+		{http.MethodDelete, "/api/books/%s", ""},
+		{http.MethodGet, "/api/books/%s/history", ""},
+		{http.MethodGet, "/api/books/%s/neighbors", ""},
+		{http.MethodGet, "/api/books/%s/similar", ""},
+		{http.MethodGet, "/api/books/%s/citation", ""},
+		{http.MethodPost, "/api/books/%s/lock", ""},
+		{http.MethodDelete, "/api/books/%s/lock", ""},
+		{http.MethodPost, "/api/books/%s/tags", `{"tags": ["classic"]}`},
+		{http.MethodDelete, "/api/books/%s/tags/classic", ""},
+		{http.MethodPut, "/api/books", `{"id": "%s", "name": "Frankenstein", "author": "Mary Shelley", "isbn": "9780306406157", "pages": 280, "year": 1818}`},
+	}
+	for name, id := range ids {
+		for _, route := range routes {
+			path, body := strings.Replace(route.path, "%s", id, 1), strings.Replace(route.body, "%s", id, 1)
+			t.Run(name+" "+route.method+" "+route.path, func(t *testing.T) {
+				req := httptest.NewRequest(route.method, path, strings.NewReader(body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				if rec.Code != http.StatusBadRequest {
+					t.Errorf("got %d, want 400: %s", rec.Code, rec.Body)
+				}
+			})
+		}
+	}
+}
+
+// Bodies that cannot be bound, such as true where a number belongs, are
+// answered with 400 rather than stored with those fields at zero
+func TestWrongJSONTypes(t *testing.T) {
+	e := newTestServer(t)
+	routes := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/api/books", `{"name": "A", "author": "B", "year": true}`},
+		{http.MethodPost, "/api/books", `{"name": "A", "author": "B", "pages": [1]}`},
+		{http.MethodPut, "/api/books", `{"id": "6acfbdef17d728e2108ee8f8", "name": "A", "author": "B", "year": true}`},
+		{http.MethodPut, "/api/books", `{"id": "6acfbdef17d728e2108ee8f8", "name": "A", "author": "B", "pages": [1]}`},
+		{http.MethodPut, "/api/books/by-isbn/9780306406157", `{"name": "A", "author": "B", "year": true}`},
+		{http.MethodPost, "/api/books/upsert", `{"name": "A", "author": "B", "isbn": "9780306406157", "pages": [1]}`},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path+" "+route.body, func(t *testing.T) {
+			req := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "body_invalid") {
+				t.Errorf("got %d, want 400 body_invalid: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+// Locks belong to a checked credential, never to the name a client sends
+func TestLockOwner(t *testing.T) {
+	cfg := config{APIKeys: []string{"key-one", "key-two"}, AdminToken: "admin-token"}
+	owner := func(headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/books/6acfbdef17d728e2108ee8f8/lock", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return cfg.lockOwner(echo.New().NewContext(req, httptest.NewRecorder()))
+	}
+
+	if got := owner(map[string]string{echo.HeaderAuthorization: "Bearer admin-token"}); got != "admin" {
+		t.Errorf("admin token owns %q, want admin", got)
+	}
+	one, two := owner(map[string]string{apiKeyHeader: "key-one"}), owner(map[string]string{apiKeyHeader: "key-two"})
+	if one == "" || one == two || strings.Contains(one, "key-one") {
+		t.Errorf("API keys own %q and %q, want distinct fingerprints", one, two)
+	}
+	for name, headers := range map[string]map[string]string{
+		"nothing":       {},
+		"a name":        {"X-User": "admin"},
+		"a wrong key":   {apiKeyHeader: "key-three"},
+		"a wrong token": {echo.HeaderAuthorization: "Bearer guess"},
+	} {
+		if got := owner(headers); got != "" {
+			t.Errorf("%s owns %q, want nothing", name, got)
+		}
+	}
+
+	e := newTestServer(t)
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/api/books/6acfbdef17d728e2108ee8f8/lock", nil)
+		req.Header.Set("X-User", "somebody else")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s lock without a credential got %d, want 401: %s", method, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Language used when the client accepts none of the catalog's
+const defaultLanguage = "en"
+
+// Messages keyed by language and then by their code. Codes are part of the
+// API and stay the same; the messages may be reworded at any time.
+var catalog = map[string]map[string]string{
+	"en": {
+		"invalid_book":       "The book is invalid",
+		"name_required":      "name is required",
+		"author_required":    "author is required",
+		"isbn_required":      "isbn is required",
+		"control_characters": "%s must not contain control characters such as newlines or null bytes (found %U at byte %d)",
+		"edition_invalid":    "edition must be something like 2, 2nd or Second revised edition, of at most 40 characters",
+		"pages_negative":     "pages must not be negative",
+		"invalid_numbers":    "The request contains invalid numbers",
+		"integer_invalid":    "%s must be a whole number from %d to %d, not %s",
+		"unknown_fields":     "The request contains unknown fields",
+		"field_unknown":      "unknown field %s",
+		"body_invalid":       "The body could not be read: %s",
+		"name_short":         "name is very short",
+		"isbn_invalid":       "isbn %q is no valid ISBN-10 or ISBN-13",
+		"year_suspicious":    "year %d is unlikely for a printed book",
+		"year_in_future":     "year lies in the future",
+		"invalid_tags":       "The tags are invalid",
+		"tag_required":       "tags must not be empty",
+		"tag_too_long":       "tag %q is longer than %d characters",
+		"tag_invalid":        "tag %q must only contain letters, digits, spaces, - and _ (found %q)",
+		"too_many_tags":      "a book can have at most %d tags",
+		"book_not_found":     "Book not found",
+		"book_gone":          "The book was deleted at %s",
+		"books_not_found":    "These books do not exist: %s",
+		"quota_exceeded":     "The catalog is limited to %d books, delete some before adding more",
+		"database_busy":      "The server is busy, please try again shortly",
+		"uri_too_long":       "The URI is longer than %d characters",
+		"too_many_headers":   "The request has more than %d header fields",
+		"path_malformed":     "The path contains control characters or .. segments",
+		"rate_limited":       "Too many requests, please slow down",
+		"query_timeout":      "The query took too long and was aborted, please narrow it down",
+		"api_key_required":   "Changes require an API key in the X-API-Key header",
+		"api_key_invalid":    "The API key is not valid",
+		"duplicate_book":     "Duplicate not allowed",
+		"isbn_ambiguous":     "%d books have the ISBN %s",
+		"book_locked":        "Locked by %s until %s",
+		"lock_unauthorized":  "Locks require an API key in the X-API-Key header or the admin token",
+		"duplicate_in_batch": "duplicate of an earlier row",
+		"bibtex_syntax":      "line %d: %s",
+		"bibtex_not_a_book":  "@%s entries are not books",
+		"bibtex_bad_year":    "year %q is not a year",
+		"already_in_catalog": "already in the catalog",
+	},
+	"de": {
+		"invalid_book":       "Das Buch ist ungültig",
+		"name_required":      "name muss angegeben werden",
+		"author_required":    "author muss angegeben werden",
+		"isbn_required":      "isbn muss angegeben werden",
+		"control_characters": "%s darf keine Steuerzeichen wie Zeilenumbrüche oder Nullbytes enthalten (%U an Byte %d gefunden)",
+		"edition_invalid":    "edition muss etwa 2, 2. oder Zweite überarbeitete Auflage lauten und darf höchstens 40 Zeichen haben",
+		"pages_negative":     "pages darf nicht negativ sein",
+		"invalid_numbers":    "Die Anfrage enthält ungültige Zahlen",
+		"integer_invalid":    "%s muss eine ganze Zahl von %d bis %d sein, nicht %s",
+		"unknown_fields":     "Die Anfrage enthält unbekannte Felder",
+		"field_unknown":      "unbekanntes Feld %s",
+		"body_invalid":       "Der Inhalt der Anfrage konnte nicht gelesen werden: %s",
+		"name_short":         "name ist sehr kurz",
+		"isbn_invalid":       "isbn %q ist keine gültige ISBN-10 oder ISBN-13",
+		"year_suspicious":    "year %d ist für ein gedrucktes Buch unwahrscheinlich",
+		"year_in_future":     "year liegt in der Zukunft",
+		"invalid_tags":       "Die Tags sind ungültig",
+		"tag_required":       "Tags dürfen nicht leer sein",
+		"tag_too_long":       "Tag %q ist länger als %d Zeichen",
+		"tag_invalid":        "Tag %q darf nur Buchstaben, Ziffern, Leerzeichen, - und _ enthalten (%q gefunden)",
+		"too_many_tags":      "ein Buch kann höchstens %d Tags haben",
+		"book_not_found":     "Buch nicht gefunden",
+		"book_gone":          "Das Buch wurde am %s gelöscht",
+		"books_not_found":    "Diese Bücher gibt es nicht: %s",
+		"quota_exceeded":     "Der Katalog ist auf %d Bücher begrenzt, bitte löschen Sie welche, bevor Sie neue hinzufügen",
+		"database_busy":      "Der Server ist ausgelastet, bitte versuchen Sie es gleich noch einmal",
+		"uri_too_long":       "Die URI ist länger als %d Zeichen",
+		"too_many_headers":   "Die Anfrage hat mehr als %d Header-Felder",
+		"path_malformed":     "Der Pfad enthält Steuerzeichen oder ..-Segmente",
+		"rate_limited":       "Zu viele Anfragen, bitte etwas langsamer",
+		"query_timeout":      "Die Abfrage hat zu lange gedauert und wurde abgebrochen, bitte schränken Sie sie ein",
+		"api_key_required":   "Änderungen erfordern einen API-Schlüssel im Header X-API-Key",
+		"api_key_invalid":    "Der API-Schlüssel ist ungültig",
+		"duplicate_book":     "Duplikate sind nicht erlaubt",
+		"isbn_ambiguous":     "%d Bücher haben die ISBN %s",
+		"book_locked":        "Gesperrt von %s bis %s",
+		"lock_unauthorized":  "Sperren erfordern einen API-Schlüssel im Header X-API-Key oder das Admin-Token",
+		"duplicate_in_batch": "Duplikat einer früheren Zeile",
+		"bibtex_syntax":      "Zeile %d: %s",
+		"bibtex_not_a_book":  "@%s-Einträge sind keine Bücher",
+		"bibtex_bad_year":    "year %q ist keine Jahreszahl",
+		"already_in_catalog": "bereits im Katalog vorhanden",
+	},
+}
+
+// Something that went wrong, identified by its code, with the values its
+// message is formatted with
+type problem struct {
+	Code string
+	Args []interface{}
+}
+
+func newProblem(code string, args ...interface{}) problem {
+	return problem{Code: code, Args: args}
+}
+
+// The message of the problem in the given language, falling back to
+// English for messages that are not translated
+func (p problem) message(lang string) string {
+	format, ok := catalog[lang][p.Code]
+	if !ok {
+		format = catalog[defaultLanguage][p.Code]
+	}
+	return fmt.Sprintf(format, p.Args...)
+}
+
+func messages(problems []problem, lang string) []string {
+	msgs := make([]string, 0, len(problems))
+	for _, p := range problems {
+		msgs = append(msgs, p.message(lang))
+	}
+	return msgs
+}
+
+// Body of a localized error: a stable code for programs and a message for
+// people, plus the individual problems of a failed validation
+type apiError struct {
+	Code    string     `json:"code"`
+	Message string     `json:"message"`
+	Errors  []apiError `json:"errors,omitempty"`
+}
+
+// Picks the language of the catalog the client prefers most according to
+// its Accept-Language header. Only the primary language is compared, so
+// "de-AT" is answered in German. On equal weights the earlier entry wins.
+func language(c echo.Context) string {
+	best, bestWeight := defaultLanguage, 0.0
+	for _, part := range strings.Split(c.Request().Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalog[primary]; !ok {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight > bestWeight {
+			best, bestWeight = primary, weight
+		}
+	}
+	return best
+}
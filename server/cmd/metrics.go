@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Number of books, counted in the background so that reading it costs
+// nothing however often the metrics are scraped.
+type bookCounter struct {
+	count     atomic.Int64
+	refreshed atomic.Int64 // unix time of the last successful count, 0 before
+}
+
+// Counts the books right away and then every interval until ctx is done.
+// The returned channel is closed once the goroutine has exited.
+func (b *bookCounter) start(ctx context.Context, coll *mongo.Collection, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			b.refresh(ctx, coll)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+func (b *bookCounter) refresh(ctx context.Context, coll *mongo.Collection) {
+	count, err := coll.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("could not refresh the book count", "error", err)
+		}
+		return
+	}
+	b.count.Store(count)
+	b.refreshed.Store(time.Now().Unix())
+}
+
+// Renders the metrics in the Prometheus text format
+func (b *bookCounter) metrics() string {
+	var sb strings.Builder
+	fmt.Fprintln(&sb, "# HELP bookstore_books Number of books in the catalog.")
+	fmt.Fprintln(&sb, "# TYPE bookstore_books gauge")
+	fmt.Fprintf(&sb, "bookstore_books %d\n", b.count.Load())
+	fmt.Fprintln(&sb, "# HELP bookstore_books_refreshed_timestamp_seconds When the number of books was last counted.")
+	fmt.Fprintln(&sb, "# TYPE bookstore_books_refreshed_timestamp_seconds gauge")
+	fmt.Fprintf(&sb, "bookstore_books_refreshed_timestamp_seconds %d\n", b.refreshed.Load())
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Number of documents a migration step updates per bulk write
+const migrationBatchSize = 500
+
+// What a single step of migrateSchema changed
+type migrationStep struct {
+	Name     string `json:"name"`
+	Batches  int    `json:"batches"`
+	Modified int64  `json:"modified"`
+}
+
+type migrationReport struct {
+	Steps []migrationStep `json:"steps"`
+}
+
+// The steps of migrateSchema in the order they are run. New steps go at the
+// end: the schema version of the data is the number of steps it has been
+// through.
+var migrationSteps = []struct {
+	name string
+	run  func(context.Context, *mongo.Collection, *migrationStep) error
+}{
+	{"created-at", backfillCreatedAt},
+}
+
+// Version of the schema this code works with
+func currentSchemaVersion() int {
+	return len(migrationSteps)
+}
+
+// Id of the document in the meta collection holding the schema version
+const schemaVersionID = "schema-version"
+
+// The schema version stored in the meta collection
+type schemaVersionDoc struct {
+	ID         string    `bson:"_id"`
+	Version    int       `bson:"version"`
+	MigratedAt time.Time `bson:"migratedat"`
+}
+
+// Schema version of the data compared to the one of the code. Version 0
+// means the data predates the schema version, which only a migration can
+// tell apart from data that needs one.
+type schemaStatus struct {
+	Version    int        `json:"version"`
+	Current    int        `json:"current"`
+	Pending    bool       `json:"pending"`
+	MigratedAt *time.Time `json:"migratedAt,omitempty"`
+}
+
+// The small collection for facts about the data as a whole, next to the
+// books
+func metaCollection(coll *mongo.Collection) *mongo.Collection {
+	return coll.Database().Collection("meta")
+}
+
+func readSchemaStatus(ctx context.Context, meta *mongo.Collection) (schemaStatus, error) {
+	status := schemaStatus{Current: currentSchemaVersion()}
+	var doc schemaVersionDoc
+	err := meta.FindOne(ctx, bson.M{"_id": schemaVersionID}).Decode(&doc)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return status, err
+	}
+	if err == nil {
+		status.Version = doc.Version
+		status.MigratedAt = &doc.MigratedAt
+	}
+	status.Pending = status.Version < status.Current
+	return status, nil
+}
+
+func writeSchemaVersion(ctx context.Context, meta *mongo.Collection, version int) error {
+	doc := schemaVersionDoc{ID: schemaVersionID, Version: version, MigratedAt: time.Now().UTC()}
+	_, err := meta.ReplaceOne(ctx, bson.M{"_id": schemaVersionID}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Records an empty catalog as being on the current schema, as there is
+// nothing to migrate. Called at startup before any books are stored.
+func prepareSchemaVersion(ctx context.Context, coll, meta *mongo.Collection) error {
+	status, err := readSchemaStatus(ctx, meta)
+	if err != nil || status.MigratedAt != nil {
+		return err
+	}
+	books, err := coll.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil || books > 0 {
+		return err
+	}
+	return writeSchemaVersion(ctx, meta, currentSchemaVersion())
+}
+
+// Brings documents written by older versions of the server up to the
+// current model. Every step only touches documents that still lack what it
+// adds, so running the migration again does nothing. The schema version is
+// raised after each step, so a migration that stops early is reported as
+// still pending.
+func migrateSchema(ctx context.Context, coll, meta *mongo.Collection) (migrationReport, error) {
+	report := migrationReport{Steps: []migrationStep{}}
+	for i, s := range migrationSteps {
+		step := migrationStep{Name: s.name}
+		err := s.run(ctx, coll, &step)
+		report.Steps = append(report.Steps, step)
+		if err != nil {
+			return report, err
+		}
+		if err = writeSchemaVersion(ctx, meta, i+1); err != nil {
+			return report, err
+		}
+		logger.Info("migration step done", "step", step.Name, "modified", step.Modified)
+	}
+	return report, nil
+}
+
+// Sets CreatedAt of books stored before it existed to the time in their
+// ObjectID, which is what addedAt assumes for them anyway.
+func backfillCreatedAt(ctx context.Context, coll *mongo.Collection, step *migrationStep) error {
+	filter := bson.M{"createdat": bson.M{"$exists": false}}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetLimit(migrationBatchSize)
+	for {
+		cursor, err := coll.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err = cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		models := make([]mongo.WriteModel, 0, len(docs))
+		for _, doc := range docs {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": doc.ID, "createdat": bson.M{"$exists": false}}).
+				SetUpdate(bson.M{"$set": bson.M{"createdat": doc.ID.Timestamp().UTC()}}))
+		}
+		res, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		step.Batches++
+		step.Modified += res.ModifiedCount
+		logger.Info("migration batch written", "step", step.Name, "batch", step.Batches, "modified", res.ModifiedCount)
+		// Nothing changed although documents matched, so the next round
+		// would find the same ones again
+		if res.ModifiedCount == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A book with the books right before and after it in a sort order, nil at
+// either end of the list
+type bookNeighbors struct {
+	Previous *Book `json:"previous"`
+	Book     Book  `json:"book"`
+	Next     *Book `json:"next"`
+}
+
+// Finds the book with the given id and its neighbors when the books are
+// sorted by sort, with _id as the tiebreaker as in the lists. Each
+// neighbor is a single query bounded by the values of the book itself, so
+// the list is never read as a whole.
+func findNeighbors(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, sort bson.D) (bookNeighbors, error) {
+	var neighbors bookNeighbors
+	raw, err := coll.FindOne(ctx, bson.M{"_id": id}, findOneTimeLimit(ctx)).Raw()
+	if err != nil {
+		return neighbors, err
+	}
+	var book BookStore
+	if err := bson.Unmarshal(raw, &book); err != nil {
+		return neighbors, err
+	}
+	neighbors.Book = convertToBook(book)
+
+	sort = stableSort(sort)
+	for _, after := range []bool{false, true} {
+		order := bson.D{}
+		for _, e := range sort {
+			direction := e.Value.(int)
+			if !after {
+				direction = -direction
+			}
+			order = append(order, bson.E{Key: e.Key, Value: direction})
+		}
+		opts := options.Find().SetSort(order).SetLimit(1)
+		found, err := findBooks(ctx, coll, keysetFilter(sort, raw, after), opts)
+		if err != nil {
+			return neighbors, err
+		}
+		if len(found) == 0 {
+			continue
+		}
+		if after {
+			neighbors.Next = &found[0]
+		} else {
+			neighbors.Previous = &found[0]
+		}
+	}
+	return neighbors, nil
+}
+
+// Matches the books that come after the pivot document in sort order, or
+// before it unless after is set. For a sort by a, b that is a beyond the
+// pivot's a, or the same a and b beyond the pivot's b.
+func keysetFilter(sort bson.D, pivot bson.Raw, after bool) bson.M {
+	var alternatives bson.A
+	equal := bson.M{}
+	for _, e := range sort {
+		value := interface{}(nil)
+		if v, err := pivot.LookupErr(e.Key); err == nil {
+			value = v
+		}
+		operator := "$gt"
+		if (e.Value.(int) < 0) == after {
+			operator = "$lt"
+		}
+		beyond := bson.M{e.Key: bson.M{operator: value}}
+		for key, v := range equal {
+			beyond[key] = v
+		}
+		alternatives = append(alternatives, beyond)
+		equal[e.Key] = value
+	}
+	return bson.M{"$or": alternatives}
+}
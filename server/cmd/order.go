@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Document field of the curated position of a book, see setDisplayOrder
+const displayOrderKey = "displayorder"
+
+// Checks the ids of PUT /api/books/order. The result is in the order of
+// the shelf; ids listed twice make the order ambiguous and are refused.
+func parseDisplayOrder(ids []string) ([]primitive.ObjectID, error) {
+	order := make([]primitive.ObjectID, 0, len(ids))
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range ids {
+		objectID, err := parseBookID(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", id, err)
+		}
+		if seen[objectID] {
+			return nil, fmt.Errorf("%s is listed twice", id)
+		}
+		seen[objectID] = true
+		order = append(order, objectID)
+	}
+	return order, nil
+}
+
+// Positions 1, 2, ... of the books of ids, keyed by their id
+func displayPositions(ids []primitive.ObjectID) map[primitive.ObjectID]int {
+	position := make(map[primitive.ObjectID]int, len(ids))
+	for i, id := range ids {
+		position[id] = i + 1
+	}
+	return position
+}
+
+// Reads the books whose position changes with the new order of ids: the
+// listed books that are not at their place yet and the books that had a
+// position but are no longer listed. Also returns the listed ids no book
+// has, as hex strings.
+func displayOrderChanges(ctx context.Context, coll *mongo.Collection, ids []primitive.ObjectID) ([]BookStore, []string, error) {
+	position := displayPositions(ids)
+	// Only a previous order, which is at most MAX_RESULTS books, is read
+	// besides the listed books
+	filter := bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{displayOrderKey: bson.M{"$exists": true}},
+	}}
+	cursor, err := coll.Find(ctx, filter, findTimeLimit(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	var found []BookStore
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, nil, err
+	}
+	var changed []BookStore
+	exists := map[primitive.ObjectID]bool{}
+	for _, book := range found {
+		exists[book.ID] = true
+		if book.DisplayOrder != position[book.ID] {
+			changed = append(changed, book)
+		}
+	}
+	missing := []string{}
+	for _, id := range ids {
+		if !exists[id] {
+			missing = append(missing, id.Hex())
+		}
+	}
+	return changed, missing, nil
+}
+
+// Replaces the curated order: the books of ids get the positions 1, 2, ...
+// and books that had a position but are no longer listed lose it. An empty
+// list clears the order. Only the changed books, as displayOrderChanges
+// found them, are written, in a single bulk write, and each one that
+// changed gets an update in the history.
+func setDisplayOrder(ctx context.Context, coll, hist *mongo.Collection, webhooks *webhookNotifier, user string, ids []primitive.ObjectID, changed []BookStore) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	position := displayPositions(ids)
+	models := make([]mongo.WriteModel, 0, len(changed))
+	before := map[primitive.ObjectID]BookStore{}
+	changedIDs := make([]primitive.ObjectID, 0, len(changed))
+	for _, book := range changed {
+		before[book.ID] = book
+		changedIDs = append(changedIDs, book.ID)
+		update := bson.M{"$unset": bson.M{displayOrderKey: ""}, "$inc": bson.M{"version": 1}}
+		filter := bson.M{"_id": book.ID, displayOrderKey: bson.M{"$exists": true}}
+		if p, ok := position[book.ID]; ok {
+			update = bson.M{"$set": bson.M{displayOrderKey: p}, "$inc": bson.M{"version": 1}}
+			filter = bson.M{"_id": book.ID, displayOrderKey: bson.M{"$ne": p}}
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update))
+	}
+	if _, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	// The history gets the books as they are stored now. One the write
+	// left alone, as it changed meanwhile, has no newer version or another
+	// position.
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": changedIDs}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var after BookStore
+		if err := cursor.Decode(&after); err != nil {
+			return err
+		}
+		if old := before[after.ID]; after.Version > old.Version && after.DisplayOrder == position[after.ID] {
+			recordAudit(ctx, hist, webhooks, actionUpdated, user, &old, &after)
+		}
+	}
+	return cursor.Err()
+}
+
+// Reads a page sorted by display order first. The database puts documents
+// without the field before all others, but uncurated books belong at the
+// end of the shelf, so the curated books are read first and the page is
+// filled up with the rest, each part in the full sort order.
+func findBooksInDisplayOrder(ctx context.Context, coll *mongo.Collection, filter bson.M, page pagination, maxResults int) ([]Book, error) {
+	limit := page.Limit
+	if limit == 0 {
+		limit = int64(maxResults) + 1
+	}
+	sort := stableSort(page.Sort)
+	ordered := bson.M{"$and": bson.A{filter, bson.M{displayOrderKey: bson.M{"$exists": true}}}}
+	books, err := findBooks(ctx, coll, ordered, options.Find().SetSort(sort).SetSkip(page.Offset).SetLimit(limit))
+	if err != nil || int64(len(books)) == limit {
+		return books, err
+	}
+
+	// The offset may reach past the curated books into the rest
+	skip := int64(0)
+	if page.Offset > 0 {
+		curated, err := countBooks(ctx, coll, ordered)
+		if err != nil {
+			return nil, err
+		}
+		skip = max(page.Offset-curated, 0)
+	}
+	unordered := bson.M{"$and": bson.A{filter, bson.M{displayOrderKey: bson.M{"$exists": false}}}}
+	rest, err := findBooks(ctx, coll, unordered, options.Find().SetSort(sort).SetSkip(skip).SetLimit(limit-int64(len(books))))
+	if err != nil {
+		return nil, err
+	}
+	return append(books, rest...), nil
+}
+
+// Whether a list is sorted by the curated order, which parseSort only
+// allows as the first field
+func sortsByDisplayOrder(sort bson.D) bool {
+	return len(sort) > 0 && sort[0].Key == displayOrderKey
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+)
+
+// A window into a list, taken from ?limit= and ?offset=, and its order,
+// taken from ?sort=. A zero Limit means the client did not ask for
+// pagination. Snapshot is the newest id a list paged with ?snapshot= may
+// contain, see snapshotFilter.
+type pagination struct {
+	Limit    int64
+	Offset   int64
+	Sort     bson.D
+	Snapshot *primitive.ObjectID
+}
+
+// Fields a list can be sorted by, and the document fields behind them.
+// "added" is the order the books were stored in, "displayOrder" the one
+// set through PUT /api/books/order.
+var sortFields = map[string]string{
+	"name":         "bookname",
+	"author":       "bookauthor",
+	"year":         "bookyear",
+	"pages":        "bookpages",
+	"added":        "_id",
+	"displayOrder": displayOrderKey,
+}
+
+// Parses a sort order such as "author,-year": comma-separated fields, each
+// descending when prefixed with "-". Used for ?sort= and DEFAULT_SORT.
+func parseSort(value string) (bson.D, error) {
+	sort := bson.D{}
+	if value == "" {
+		return sort, nil
+	}
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		direction := 1
+		if name, ok := strings.CutPrefix(field, "-"); ok {
+			field, direction = name, -1
+		}
+		key, ok := sortFields[field]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q, expected one of added, author, displayOrder, name, pages, year", field)
+		}
+		// Books without a position come last, which only works for the
+		// first field, see findBooksInDisplayOrder
+		if key == displayOrderKey && len(sort) > 0 {
+			return nil, fmt.Errorf("%q can only be the first field to sort by", field)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%q is sorted by twice", field)
+		}
+		seen[key] = true
+		sort = append(sort, bson.E{Key: key, Value: direction})
+	}
+	return sort, nil
+}
+
+// Appends _id to sort unless it is there already. Books that are equal in
+// every sorted field would otherwise come back in whatever order the
+// database happens to produce, which can change between two identical
+// requests and make pages overlap.
+func stableSort(sort bson.D) bson.D {
+	for _, e := range sort {
+		if e.Key == "_id" {
+			return sort
+		}
+	}
+	return append(append(bson.D{}, sort...), bson.E{Key: "_id", Value: 1})
+}
+
+// Parses the PAGE_SIZES setting: comma-separated route=size pairs such as
+// "/api/books=50,/api/books/query=10". Routes are written without BASE_PATH.
+func parsePageSizes(value string, maxResults int) (map[string]int, error) {
+	sizes := map[string]int{}
+	if value == "" {
+		return sizes, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		route, size, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.HasPrefix(route, "/api/") {
+			return nil, fmt.Errorf("PAGE_SIZES: %q must look like /api/books=50", pair)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 || n > maxResults {
+			return nil, fmt.Errorf("PAGE_SIZES: the size for %s must be a number from 1 to MAX_RESULTS (%d)", route, maxResults)
+		}
+		sizes[route] = n
+	}
+	return sizes, nil
+}
+
+// Page size of the route when the client does not send ?limit=: the one
+// from PAGE_SIZES, otherwise PAGE_SIZE. Zero means the whole list is sent.
+func (cfg config) pageSize(c echo.Context) int {
+	if size, ok := cfg.PageSizes[strings.TrimPrefix(c.Path(), cfg.BasePath)]; ok {
+		return size
+	}
+	return cfg.PageSize
+}
+
+// Reads the pagination parameters. Without ?limit= the page is
+// defaultLimit books long, or the whole list for a zero defaultLimit, and
+// without ?sort= it is in defaultSort order. A limit above maxResults is
+// refused rather than silently lowered, so the client notices.
+func parsePagination(c echo.Context, defaultLimit, maxResults int, defaultSort bson.D) (pagination, error) {
+	page := pagination{Limit: int64(defaultLimit), Sort: defaultSort}
+	if value := c.QueryParam("sort"); value != "" {
+		sort, err := parseSort(value)
+		if err != nil {
+			return page, err
+		}
+		page.Sort = sort
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("invalid limit %q", value)
+		}
+		if limit > int64(maxResults) {
+			return page, fmt.Errorf("limit may be at most %d", maxResults)
+		}
+		page.Limit = limit
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("invalid offset %q", value)
+		}
+		page.Offset = offset
+	}
+	// ?snapshot=true starts a snapshot, which needs the database and is
+	// left to respondBookPage; later pages send the id it answered with
+	if value := c.QueryParam("snapshot"); value != "" && value != "true" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return page, fmt.Errorf("invalid snapshot %q", value)
+		}
+		page.Snapshot = &id
+	}
+	return page, nil
+}
+
+// Reads ?limit= of a route that answers with the first few of a ranking
+// rather than with pages: def without it, and otherwise a number from 1 to
+// max.
+func parseLimit(c echo.Context, def, max int) (int, error) {
+	value := c.QueryParam("limit")
+	if value == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > max {
+		return 0, fmt.Errorf("limit must be a number between 1 and %d", max)
+	}
+	return limit, nil
+}
+
+// Id of the newest book, which becomes the snapshot of a list. Ids grow
+// with the time they were made, so books added later have larger ones. An
+// empty catalog gives the zero id, which leaves later books out as well.
+func latestBookID(ctx context.Context, coll *mongo.Collection) (primitive.ObjectID, error) {
+	var latest BookStore
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}}).SetProjection(bson.M{"_id": 1})
+	err := coll.FindOne(ctx, bson.M{}, opts, findOneTimeLimit(ctx)).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, nil
+	}
+	return latest.ID, err
+}
+
+// Restricts filter to the books that existed when the snapshot of the page
+// was taken. Paging through a busy catalog by offset would otherwise skip
+// or repeat books as others are added, and the total would drift. Books
+// deleted or changed in the meantime still show, and ids made by another
+// instance within the same second may fall either side.
+func (p pagination) snapshotFilter(filter bson.M) bson.M {
+	if p.Snapshot == nil {
+		return filter
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$lte": *p.Snapshot}}}}
+}
+
+// Find options for the page. Without pagination one document more than
+// maxResults is fetched, which is how an oversized result is detected
+// without counting the collection first.
+func (p pagination) findOptions(maxResults int) *options.FindOptions {
+	opts := options.Find().SetSort(stableSort(p.Sort)).SetSkip(p.Offset)
+	if p.Limit > 0 {
+		return opts.SetLimit(p.Limit)
+	}
+	return opts.SetLimit(int64(maxResults) + 1)
+}
+
+// Meta data of a paginated list for the response envelope
+func (p pagination) meta(total int64) map[string]interface{} {
+	meta := map[string]interface{}{
+		"total":  total,
+		"limit":  p.Limit,
+		"offset": p.Offset,
+	}
+	if p.Snapshot != nil {
+		meta["snapshot"] = p.Snapshot.Hex()
+	}
+	return meta
+}
+
+func tooManyResults(maxResults int) string {
+	return fmt.Sprintf("More than %d books match, please paginate with ?limit= and ?offset=", maxResults)
+}
+
+// Concurrent identical list reads, such as several widgets of a page
+// loading /api/books at once, share a single trip to the database
+var bookPageReads singleflight.Group
+
+// A page of books as read for respondBookPage. Total is only counted for
+// paginated requests.
+type bookPage struct {
+	Books []Book
+	Total int64
+}
+
+// Reads the page of books matching filter, joining a read of the same page
+// that is already running. The shared read must not fail because the client
+// that started it went away, so it ignores cancellation. A read that starts
+// right after a write may thus still get what was stored before it.
+func readBookPage(ctx context.Context, coll *mongo.Collection, filter bson.M, page pagination, maxResults int) (bookPage, error) {
+	key, err := bookPageKey(filter, page, maxResults)
+	if err != nil {
+		return bookPage{}, err
+	}
+	ctx = context.WithoutCancel(ctx)
+	result, err, _ := bookPageReads.Do(key, func() (interface{}, error) {
+		var read bookPage
+		var err error
+		if sortsByDisplayOrder(page.Sort) {
+			read.Books, err = findBooksInDisplayOrder(ctx, coll, filter, page, maxResults)
+		} else {
+			read.Books, err = findBooks(ctx, coll, filter, page.findOptions(maxResults))
+		}
+		if err != nil {
+			return read, err
+		}
+		if page.Limit > 0 {
+			read.Total, err = countBooks(ctx, coll, filter)
+		}
+		return read, err
+	})
+	return result.(bookPage), err
+}
+
+// Key of a read in bookPageReads. Reads share it exactly when they would
+// ask the database the same: encoding/json writes map keys in order and
+// ids and regular expressions by their value.
+func bookPageKey(filter bson.M, page pagination, maxResults int) (string, error) {
+	key, err := json.Marshal([]interface{}{filter, page, maxResults})
+	return string(key), err
+}
+
+// Answers with the books matching filter, one page of them if the client
+// asked for one or the route has a default page size, and refuses results
+// larger than maxResults otherwise.
+func respondBookPage(c echo.Context, coll *mongo.Collection, filter bson.M, defaultLimit, maxResults int, defaultSort bson.D) error {
+	page, err := parsePagination(c, defaultLimit, maxResults, defaultSort)
+	if err != nil {
+		return respondError(c, 400, err.Error())
+	}
+	if c.QueryParam("snapshot") == "true" {
+		latest, err := latestBookID(c.Request().Context(), coll)
+		if err != nil {
+			return respondDBError(c, err, "Could not fetch the books")
+		}
+		page.Snapshot = &latest
+	}
+	read, err := readBookPage(c.Request().Context(), coll, page.snapshotFilter(filter), page, maxResults)
+	if err != nil {
+		return respondDBError(c, err, "Could not fetch the books")
+	}
+	// Also in the header, as clients without the envelope never see the
+	// meta data
+	if page.Snapshot != nil {
+		c.Response().Header().Set("X-Snapshot", page.Snapshot.Hex())
+	}
+	if page.Limit == 0 {
+		if len(read.Books) > maxResults {
+			return respondError(c, 400, tooManyResults(maxResults))
+		}
+		return respond(c, 200, read.Books)
+	}
+	return respondMeta(c, 200, read.Books, page.meta(read.Total))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStableSort(t *testing.T) {
+	tests := []struct {
+		name string
+		sort bson.D
+		want bson.D
+	}{
+		{"no order", nil, bson.D{{Key: "_id", Value: 1}}},
+		{"one field", bson.D{{Key: "bookauthor", Value: 1}}, bson.D{{Key: "bookauthor", Value: 1}, {Key: "_id", Value: 1}}},
+		{
+			"several fields",
+			bson.D{{Key: "bookyear", Value: -1}, {Key: "bookname", Value: 1}},
+			bson.D{{Key: "bookyear", Value: -1}, {Key: "bookname", Value: 1}, {Key: "_id", Value: 1}},
+		},
+		{"by id already", bson.D{{Key: "_id", Value: -1}}, bson.D{{Key: "_id", Value: -1}}},
+		{
+			"id in the middle",
+			bson.D{{Key: "bookyear", Value: 1}, {Key: "_id", Value: -1}, {Key: "bookname", Value: 1}},
+			bson.D{{Key: "bookyear", Value: 1}, {Key: "_id", Value: -1}, {Key: "bookname", Value: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := stableSort(test.sort); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// The caller's order is often DEFAULT_SORT, which is shared by every
+// request and must not grow with each of them
+func TestStableSortKeepsTheCallersOrder(t *testing.T) {
+	sort := make(bson.D, 1, 2)
+	sort[0] = bson.E{Key: "bookauthor", Value: 1}
+	stableSort(sort)
+	if got := sort[:cap(sort)][1]; got.Key != "" {
+		t.Errorf("stableSort wrote %v into the caller's array", got)
+	}
+}
+
+// Two identical requests ask the database for the same total order
+func TestFindOptionsAreStable(t *testing.T) {
+	sort, err := parseSort("author,-year")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := pagination{Limit: 10, Offset: 20, Sort: sort}
+	first, second := page.findOptions(1000), page.findOptions(1000)
+	want := bson.D{{Key: "bookauthor", Value: 1}, {Key: "bookyear", Value: -1}, {Key: "_id", Value: 1}}
+	if !reflect.DeepEqual(first.Sort, want) {
+		t.Errorf("sorted by %v, want %v", first.Sort, want)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same page is read with %v and %v", first, second)
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	snapshot, _ := primitive.ObjectIDFromHex("65a1b2c3d4e5f60718293a4b")
+	defaultSort := bson.D{{Key: "bookname", Value: 1}}
+	tests := []struct {
+		name  string
+		query string
+		want  pagination
+		err   bool
+	}{
+		{"defaults", "", pagination{Limit: 20, Sort: defaultSort}, false},
+		{"window", "limit=5&offset=10", pagination{Limit: 5, Offset: 10, Sort: defaultSort}, false},
+		{"order", "sort=-year", pagination{Limit: 20, Sort: bson.D{{Key: "bookyear", Value: -1}}}, false},
+		{"snapshot", "snapshot=65a1b2c3d4e5f60718293a4b", pagination{Limit: 20, Sort: defaultSort, Snapshot: &snapshot}, false},
+		{"snapshot in upper case", "snapshot=65A1B2C3D4E5F60718293A4B", pagination{Limit: 20, Sort: defaultSort, Snapshot: &snapshot}, false},
+		// Left to respondBookPage, which asks the database for the newest id
+		{"new snapshot", "snapshot=true", pagination{Limit: 20, Sort: defaultSort}, false},
+		{"invalid snapshot", "snapshot=yesterday", pagination{}, true},
+		{"zero limit", "limit=0", pagination{}, true},
+		{"limit above the maximum", "limit=101", pagination{}, true},
+		{"negative offset", "offset=-1", pagination{}, true},
+		{"unknown order", "sort=price", pagination{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/books?"+test.query, nil)
+			page, err := parsePagination(echo.New().NewContext(req, httptest.NewRecorder()), 20, 100, defaultSort)
+			if test.err {
+				if err == nil {
+					t.Errorf("no error, got %+v", page)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(page, test.want) {
+				t.Errorf("got  %+v\nwant %+v", page, test.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotFilter(t *testing.T) {
+	snapshot := primitive.NewObjectID()
+	filter := bson.M{"bookauthor": "Mary Shelley"}
+	if got := (pagination{}).snapshotFilter(filter); !reflect.DeepEqual(got, filter) {
+		t.Errorf("without a snapshot got %v, want the filter itself", got)
+	}
+	want := bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$lte": snapshot}}}}
+	if got := (pagination{Snapshot: &snapshot}).snapshotFilter(filter); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPaginationMeta(t *testing.T) {
+	page := pagination{Limit: 10, Offset: 30}
+	want := map[string]interface{}{"total": int64(42), "limit": int64(10), "offset": int64(30)}
+	if got := page.meta(42); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	snapshot := primitive.NewObjectID()
+	page.Snapshot = &snapshot
+	want["snapshot"] = snapshot.Hex()
+	if got := page.meta(42); !reflect.DeepEqual(got, want) {
+		t.Errorf("with a snapshot got %v, want %v", got, want)
+	}
+}
+
+// Reads are only shared by requests that would read the same books
+func TestBookPageKey(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	filter := func() bson.M {
+		return bson.M{"bookyear": bson.M{"$gte": 1900}, "bookauthor": primitive.Regex{Pattern: "^shelley$", Options: "i"}}
+	}
+	page := pagination{Limit: 10, Sort: bson.D{{Key: "bookname", Value: 1}}, Snapshot: &first}
+	key := func(t *testing.T, filter bson.M, page pagination, maxResults int) string {
+		t.Helper()
+		key, err := bookPageKey(filter, page, maxResults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	base := key(t, filter(), page, 100)
+	for i := 0; i < 10; i++ {
+		if again := key(t, filter(), page, 100); again != base {
+			t.Fatalf("the same read has the keys %s and %s", base, again)
+		}
+	}
+
+	other := func(change func(filter bson.M, page *pagination) int) string {
+		f, p := filter(), page
+		maxResults := change(f, &p)
+		return key(t, f, p, maxResults)
+	}
+	differing := map[string]string{
+		"snapshot":    other(func(_ bson.M, p *pagination) int { p.Snapshot = &second; return 100 }),
+		"no snapshot": other(func(_ bson.M, p *pagination) int { p.Snapshot = nil; return 100 }),
+		"offset":      other(func(_ bson.M, p *pagination) int { p.Offset = 10; return 100 }),
+		"limit":       other(func(_ bson.M, p *pagination) int { p.Limit = 20; return 100 }),
+		"direction": other(func(_ bson.M, p *pagination) int {
+			p.Sort = bson.D{{Key: "bookname", Value: -1}}
+			return 100
+		}),
+		"pattern": other(func(f bson.M, _ *pagination) int {
+			f["bookauthor"] = primitive.Regex{Pattern: "^shelley", Options: "i"}
+			return 100
+		}),
+		"regex options": other(func(f bson.M, _ *pagination) int {
+			f["bookauthor"] = primitive.Regex{Pattern: "^shelley$"}
+			return 100
+		}),
+		"value type": other(func(f bson.M, _ *pagination) int {
+			f["bookyear"] = bson.M{"$gte": "1900"}
+			return 100
+		}),
+		"maximum": other(func(bson.M, *pagination) int { return 50 }),
+	}
+	for name, changed := range differing {
+		if changed == base {
+			t.Errorf("a read with another %s shares the key %s", name, base)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+		err   bool
+	}{
+		{"", 5, false},
+		{"limit=1", 1, false},
+		{"limit=50", 50, false},
+		{"limit=0", 0, true},
+		{"limit=51", 0, true},
+		{"limit=-3", 0, true},
+		{"limit=ten", 0, true},
+		{"limit=2.5", 0, true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/books/x/similar?"+test.query, nil)
+		got, err := parseLimit(echo.New().NewContext(req, httptest.NewRecorder()), 5, 50)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("%q: got %d, %v", test.query, got, err)
+		}
+	}
+}
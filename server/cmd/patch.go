@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Body of a PATCH request. Fields that are left out keep their value, which
+// is why they are pointers: a missing field and a zero value differ.
+type bookPatch struct {
+	Name     *string `json:"name"`
+	Subtitle *string `json:"subtitle"`
+	Edition  *string `json:"edition"`
+	Author   *string `json:"author"`
+	ISBN     *string `json:"isbn"`
+	Pages    *int    `json:"pages"`
+	Year     *int    `json:"year"`
+}
+
+// Returns the book with the patch applied
+func (p bookPatch) apply(book BookStore, isbns isbnConversion) BookStore {
+	if p.Name != nil {
+		book.BookName = *p.Name
+	}
+	if p.Subtitle != nil {
+		book.BookSubtitle = *p.Subtitle
+	}
+	if p.Edition != nil {
+		book.BookEdition = *p.Edition
+	}
+	if p.Author != nil {
+		book.BookAuthor = *p.Author
+	}
+	if p.ISBN != nil {
+		book.BookISBN, book.OriginalISBN = isbns.storedISBN(*p.ISBN)
+	}
+	if p.Pages != nil {
+		book.BookPages = *p.Pages
+	}
+	if p.Year != nil {
+		book.BookYear = *p.Year
+	}
+	return book
+}
+
+// The fields that differ between two versions of a book, keyed by their
+// JSON name and holding the new value
+func changedFields(before, after Book) fields {
+	changed := fields{}
+	if before.Name != after.Name {
+		changed["name"] = after.Name
+	}
+	if before.Subtitle != after.Subtitle {
+		changed["subtitle"] = after.Subtitle
+	}
+	if before.Edition != after.Edition {
+		changed["edition"] = after.Edition
+	}
+	if before.Author != after.Author {
+		changed["author"] = after.Author
+	}
+	if before.ISBN != after.ISBN {
+		changed["isbn"] = after.ISBN
+	}
+	if before.Pages != after.Pages {
+		changed["pages"] = after.Pages
+	}
+	if before.Year != after.Year {
+		changed["year"] = after.Year
+	}
+	return changed
+}
+
+// Stores the patched fields of a book and bumps its version in one step,
+// returning the book as it is stored afterwards.
+func patchBook(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, patched BookStore) (BookStore, error) {
+	update := bson.M{"$set": editableFields(patched), "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var after BookStore
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&after)
+	return after, err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Most conditions a single query may combine
+const maxQueryConditions = 20
+
+// Body of POST /api/books/query. All conditions have to hold.
+type bookQuery struct {
+	Filters []queryCondition `json:"filters"`
+}
+
+// A single condition such as {"field": "year", "op": "gt", "value": 1900}
+type queryCondition struct {
+	Field string          `json:"field"`
+	Op    string          `json:"op"`
+	Value json.RawMessage `json:"value"`
+}
+
+// The fields a query may use, keyed by their JSON name, with the stored
+// name and whether they hold text or numbers
+var queryFields = map[string]struct {
+	stored string
+	text   bool
+}{
+	"name":     {"bookname", true},
+	"subtitle": {"booksubtitle", true},
+	"edition":  {"bookedition", true},
+	"author":   {"bookauthor", true},
+	"isbn":     {"bookisbn", true},
+	"pages":    {"bookpages", false},
+	"year":     {"bookyear", false},
+}
+
+// Operators translated into their MongoDB counterpart. contains is
+// handled separately, and only for text fields.
+var queryOperators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+}
+
+// Translates a query into a filter. Only the fields and operators listed
+// above are accepted and every value has to have the type of its field, so
+// nothing from the request ends up in the filter as it is.
+func (q bookQuery) filter() (bson.M, error) {
+	if len(q.Filters) > maxQueryConditions {
+		return nil, fmt.Errorf("a query may have at most %d filters", maxQueryConditions)
+	}
+	conditions := bson.A{}
+	for i, cond := range q.Filters {
+		field, ok := queryFields[cond.Field]
+		if !ok {
+			return nil, fmt.Errorf("filters[%d]: unknown field %q", i, cond.Field)
+		}
+		value, err := cond.value(field.text)
+		if err != nil {
+			return nil, fmt.Errorf("filters[%d]: %v", i, err)
+		}
+
+		if cond.Op == "contains" {
+			if !field.text {
+				return nil, fmt.Errorf("filters[%d]: contains only works on text fields", i)
+			}
+			pattern := regexp.QuoteMeta(value.(string))
+			conditions = append(conditions, bson.M{field.stored: primitive.Regex{Pattern: pattern, Options: "i"}})
+			continue
+		}
+		op, ok := queryOperators[cond.Op]
+		if !ok {
+			return nil, fmt.Errorf("filters[%d]: unknown operator %q", i, cond.Op)
+		}
+		conditions = append(conditions, bson.M{field.stored: bson.M{op: value}})
+	}
+	if len(conditions) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// Decodes the value of a condition as a string or a whole number. null
+// is neither, though encoding/json would take it as "" or 0.
+func (cond queryCondition) value(text bool) (interface{}, error) {
+	isNull := string(bytes.TrimSpace(cond.Value)) == "null"
+	if text {
+		var s string
+		if err := json.Unmarshal(cond.Value, &s); err != nil || isNull {
+			return nil, fmt.Errorf("the value of %s has to be a string", cond.Field)
+		}
+		return s, nil
+	}
+	var n float64
+	if err := json.Unmarshal(cond.Value, &n); err != nil || isNull || n != math.Trunc(n) || math.Abs(n) > math.MaxInt32 {
+		return nil, fmt.Errorf("the value of %s has to be a whole number", cond.Field)
+	}
+	return int(n), nil
+}
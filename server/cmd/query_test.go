@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBookQueryFilter(t *testing.T) {
+	and := func(conditions ...bson.M) bson.M {
+		all := bson.A{}
+		for _, c := range conditions {
+			all = append(all, c)
+		}
+		return bson.M{"$and": all}
+	}
+	tests := []struct {
+		name string
+		body string
+		want bson.M
+		// Part of the error, when the query is refused
+		err string
+	}{
+		{"no filters", `{"filters": []}`, bson.M{}, ""},
+		{
+			"comparisons",
+			`{"filters": [{"field": "year", "op": "gte", "value": 1900}, {"field": "author", "op": "eq", "value": "Mary Shelley"}]}`,
+			and(bson.M{"bookyear": bson.M{"$gte": 1900}}, bson.M{"bookauthor": bson.M{"$eq": "Mary Shelley"}}),
+			"",
+		},
+		{"negative number", `{"filters": [{"field": "year", "op": "lt", "value": -800}]}`, and(bson.M{"bookyear": bson.M{"$lt": -800}}), ""},
+		{"whole float", `{"filters": [{"field": "pages", "op": "eq", "value": 300.0}]}`, and(bson.M{"bookpages": bson.M{"$eq": 300}}), ""},
+		{
+			"contains",
+			`{"filters": [{"field": "name", "op": "contains", "value": "frank"}]}`,
+			and(bson.M{"bookname": primitive.Regex{Pattern: "frank", Options: "i"}}),
+			"",
+		},
+		{
+			"regular expression characters in contains",
+			`{"filters": [{"field": "name", "op": "contains", "value": "C++ (2nd ed.) [*]? ^a|b$"}]}`,
+			and(bson.M{"bookname": primitive.Regex{Pattern: `C\+\+ \(2nd ed\.\) \[\*\]\? \^a\|b\$`, Options: "i"}}),
+			"",
+		},
+		// Only ever compared as a value, never read as an operator
+		{"operator as a value", `{"filters": [{"field": "isbn", "op": "eq", "value": "$ne"}]}`, and(bson.M{"bookisbn": bson.M{"$eq": "$ne"}}), ""},
+
+		{"unknown field", `{"filters": [{"field": "price", "op": "eq", "value": 10}]}`, nil, `unknown field "price"`},
+		{"stored field name", `{"filters": [{"field": "bookname", "op": "eq", "value": "x"}]}`, nil, `unknown field "bookname"`},
+		{"field in another case", `{"filters": [{"field": "Name", "op": "eq", "value": "x"}]}`, nil, `unknown field "Name"`},
+		{"operator as a field", `{"filters": [{"field": "$where", "op": "eq", "value": "1"}]}`, nil, `unknown field "$where"`},
+		{"unknown operator", `{"filters": [{"field": "year", "op": "between", "value": 1900}]}`, nil, `unknown operator "between"`},
+		{"MongoDB operator", `{"filters": [{"field": "name", "op": "$regex", "value": ".*"}]}`, nil, `unknown operator "$regex"`},
+		{"missing operator", `{"filters": [{"field": "year", "value": 1900}]}`, nil, `unknown operator ""`},
+		{"operator object as a value", `{"filters": [{"field": "name", "op": "eq", "value": {"$ne": null}}]}`, nil, "has to be a string"},
+		{"contains on a number field", `{"filters": [{"field": "pages", "op": "contains", "value": 3}]}`, nil, "only works on text fields"},
+		{"text on a number field", `{"filters": [{"field": "pages", "op": "eq", "value": "300"}]}`, nil, "has to be a whole number"},
+		{"number on a text field", `{"filters": [{"field": "isbn", "op": "eq", "value": 9780306406157}]}`, nil, "has to be a string"},
+		{"fraction", `{"filters": [{"field": "pages", "op": "gt", "value": 292.5}]}`, nil, "has to be a whole number"},
+		{"above 32 bits", `{"filters": [{"field": "year", "op": "lt", "value": 3000000000}]}`, nil, "has to be a whole number"},
+		{"below 32 bits", `{"filters": [{"field": "year", "op": "gt", "value": -3000000000}]}`, nil, "has to be a whole number"},
+		{"huge exponent", `{"filters": [{"field": "year", "op": "gt", "value": 1e400}]}`, nil, "has to be a whole number"},
+		{"missing value", `{"filters": [{"field": "year", "op": "eq"}]}`, nil, "has to be a whole number"},
+		{"null text", `{"filters": [{"field": "name", "op": "eq", "value": null}]}`, nil, "has to be a string"},
+		{"null number", `{"filters": [{"field": "year", "op": "eq", "value": null}]}`, nil, "has to be a whole number"},
+		{
+			"position of the failing filter",
+			`{"filters": [{"field": "year", "op": "eq", "value": 1900}, {"field": "year", "op": "eq", "value": 1.5}]}`,
+			nil, "filters[1]",
+		},
+		{"too many filters", `{"filters": [` + strings.Repeat(`{"field": "year", "op": "eq", "value": 1},`, maxQueryConditions) + `{"field": "year", "op": "eq", "value": 1}]}`, nil, "at most"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var q bookQuery
+			if err := json.Unmarshal([]byte(test.body), &q); err != nil {
+				t.Fatal(err)
+			}
+			got, err := q.filter()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want one with %q; filter %v", err, test.err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got  %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type queryTimeKey struct{}
+
+// Bounds the reads of the requests to limit (QUERY_MAX_TIME), 0 for no
+// limit. The context deadline of a request only makes the client stop
+// waiting; this stops the query on the server, so a runaway aggregation
+// does not keep the database busy after the client has given up.
+func queryTimeMiddleware(limit time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if limit > 0 {
+				req := c.Request()
+				c.SetRequest(req.WithContext(context.WithValue(req.Context(), queryTimeKey{}, limit)))
+			}
+			return next(c)
+		}
+	}
+}
+
+// Returns the limit stored in ctx by queryTimeMiddleware, or 0 for reads
+// made outside of a request
+func queryTimeFrom(ctx context.Context) time.Duration {
+	limit, _ := ctx.Value(queryTimeKey{}).(time.Duration)
+	return limit
+}
+
+// Options that bound a find to the limit of ctx, meant to be passed after
+// the other options of the query
+func findTimeLimit(ctx context.Context) *options.FindOptions {
+	opts := options.Find()
+	if limit := queryTimeFrom(ctx); limit > 0 {
+		opts.SetMaxTime(limit)
+	}
+	return opts
+}
+
+func findOneTimeLimit(ctx context.Context) *options.FindOneOptions {
+	opts := options.FindOne()
+	if limit := queryTimeFrom(ctx); limit > 0 {
+		opts.SetMaxTime(limit)
+	}
+	return opts
+}
+
+func aggregateTimeLimit(ctx context.Context) *options.AggregateOptions {
+	opts := options.Aggregate()
+	if limit := queryTimeFrom(ctx); limit > 0 {
+		opts.SetMaxTime(limit)
+	}
+	return opts
+}
+
+func countTimeLimit(ctx context.Context) *options.CountOptions {
+	opts := options.Count()
+	if limit := queryTimeFrom(ctx); limit > 0 {
+		opts.SetMaxTime(limit)
+	}
+	return opts
+}
+
+func distinctTimeLimit(ctx context.Context) *options.DistinctOptions {
+	opts := options.Distinct()
+	if limit := queryTimeFrom(ctx); limit > 0 {
+		opts.SetMaxTime(limit)
+	}
+	return opts
+}
+
+// Answers a request whose database operation failed. Operations the
+// database aborted after QUERY_MAX_TIME, or that ran into the request's
+// deadline, get 504 with an explanation; anything else is a 500 with
+// message.
+func respondDBError(c echo.Context, err error, message string) error {
+	if mongo.IsTimeout(err) {
+		logger.WarnContext(c.Request().Context(), "query aborted", "route", c.Path(), "error", err)
+		return respondProblem(c, 504, newProblem("query_timeout"))
+	}
+	return respondError(c, 500, message)
+}
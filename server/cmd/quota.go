@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// Adjusts the count right after books were added (n > 0) or deleted, so it
+// stays close to the truth between two refreshes
+func (b *bookCounter) add(n int64) {
+	b.count.Add(n)
+}
+
+// Reports whether n more books fit into the catalog when it may hold at most
+// max books, 0 meaning no limit. The count kept for /metrics is used rather
+// than a count on every insert, so the quota is approximate: concurrent
+// inserts may overshoot it by a few books.
+func (b *bookCounter) allows(n int, max int) bool {
+	return max == 0 || b.count.Load()+int64(n) <= int64(max)
+}
+
+func respondQuotaExceeded(c echo.Context, max int) error {
+	return respondProblem(c, 403, newProblem("quota_exceeded", max))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// Limits how many public reads a single client address may send per
+// minute, with bursts of up to burst requests. Public are the reads below
+// prefix, GET and HEAD as well as the routes in readOnly that are sent as
+// POST but store nothing, from clients that authenticate neither with an
+// API key nor as admin. Authenticated clients are not limited here.
+// Clients over the limit get 429 with Retry-After.
+func publicRateLimiter(perMinute, burst int, keys []string, adminToken, prefix string, readOnly ...string) echo.MiddlewareFunc {
+	retryAfter := strconv.Itoa((60 + perMinute - 1) / perMinute)
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			if !strings.HasPrefix(c.Path(), prefix) {
+				return true
+			}
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead:
+			default:
+				if !slices.Contains(readOnly, c.Path()) {
+					return true
+				}
+			}
+			return isAdmin(c, adminToken) || validAPIKey(c.Request().Header.Get(apiKeyHeader), keys)
+		},
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(perMinute) / 60),
+			Burst:     burst,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, client string, err error) error {
+			logger.WarnContext(c.Request().Context(), "rate limit exceeded", "route", c.Path(), "client", client)
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return respondProblem(c, 429, newProblem("rate_limited"))
+		},
+	})
+}
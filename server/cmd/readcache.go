@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// Results of reads that change rarely but are asked for on every page load,
+// such as the years for the filter drop-downs. Concurrent misses share one
+// trip to the database like readBookPage does, and the result is kept for
+// ttl or until a write invalidates it. Writes made through other instances
+// of the server are only noticed once ttl has passed.
+type readCache struct {
+	ttl   time.Duration
+	reads singleflight.Group
+
+	mu sync.Mutex
+	// Incremented by every invalidation, so reads that started before one
+	// neither store their result nor are joined by later requests
+	generation uint64
+	entries    map[string]cachedRead
+}
+
+type cachedRead struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{ttl: ttl, entries: map[string]cachedRead{}}
+}
+
+// Returns the cached result of key or runs load for it. The shared load
+// must not fail because the client that started it went away, so it
+// ignores cancellation. Failures are not cached. A zero ttl keeps nothing
+// and only coalesces the reads.
+func (rc *readCache) get(ctx context.Context, key string, load func(context.Context) (interface{}, error)) (interface{}, error) {
+	rc.mu.Lock()
+	generation := rc.generation
+	entry, ok := rc.entries[key]
+	rc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	value, err, _ := rc.reads.Do(fmt.Sprintf("%d/%s", generation, key), func() (interface{}, error) {
+		value, err := load(ctx)
+		if err != nil || rc.ttl <= 0 {
+			return value, err
+		}
+		rc.mu.Lock()
+		if rc.generation == generation {
+			rc.entries[key] = cachedRead{value: value, expires: time.Now().Add(rc.ttl)}
+		}
+		rc.mu.Unlock()
+		return value, nil
+	})
+	return value, err
+}
+
+// Drops every cached result
+func (rc *readCache) invalidate() {
+	rc.mu.Lock()
+	rc.generation++
+	clear(rc.entries)
+	rc.mu.Unlock()
+}
+
+// Invalidates the cache with every request below apiPrefix that may have
+// changed the catalog, which is any successful one that is not a read. It
+// happens as the status line goes out, so a client that reads right after
+// its change already gets the new result. Doing it here rather than in each
+// handler keeps new routes from being forgotten; a write that changed
+// neither authors nor years costs one more read.
+func (rc *readCache) invalidateOnWrite(apiPrefix string, readOnly ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if !strings.HasPrefix(c.Path(), apiPrefix) || slices.Contains(readOnly, c.Path()) {
+				return next(c)
+			}
+			c.Response().Before(func() {
+				if c.Response().Status < 400 {
+					rc.invalidate()
+				}
+			})
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Most header fields a request may have. Real clients send a few dozen at
+// most; thousands of tiny ones fit into MAX_HEADER_BYTES but only cost
+// time to parse.
+const maxHeaderFields = 100
+
+// Rejects requests no real client sends before they are routed: a URI
+// longer than maxURILength (414), more than maxHeaderFields header fields
+// (431) or a path with control characters or ".." segments once decoded
+// (400). The total size of the headers is limited by the server itself
+// with MAX_HEADER_BYTES, which also answers with 431.
+func requestLimitsMiddleware(maxURILength int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if len(req.RequestURI) > maxURILength {
+				logger.WarnContext(req.Context(), "request rejected", "reason", "uri too long", "length", len(req.RequestURI), "client", c.RealIP())
+				return respondProblem(c, 414, newProblem("uri_too_long", maxURILength))
+			}
+			fields := 0
+			for _, values := range req.Header {
+				fields += len(values)
+			}
+			if fields > maxHeaderFields {
+				logger.WarnContext(req.Context(), "request rejected", "reason", "too many header fields", "fields", fields, "client", c.RealIP())
+				return respondProblem(c, 431, newProblem("too_many_headers", maxHeaderFields))
+			}
+			if suspiciousPath(req.URL) {
+				logger.WarnContext(req.Context(), "request rejected", "reason", "malformed path", "path", req.URL.EscapedPath(), "client", c.RealIP())
+				return respondProblem(c, 400, newProblem("path_malformed"))
+			}
+			return next(c)
+		}
+	}
+}
+
+// Reports whether the decoded path contains control characters, such as an
+// encoded NUL byte, or climbs up with ".."
+func suspiciousPath(u *url.URL) bool {
+	if strings.IndexFunc(u.Path, unicode.IsControl) >= 0 {
+		return true
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
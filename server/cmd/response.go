@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Key under which responseMiddleware stores the options of a request
+const responseOptionsKey = "responseOptions"
+
+// How the JSON responses of a single request are written
+type responseOptions struct {
+	// Wrap the payload into {"data": ..., "meta": ..., "error": ...}
+	Envelope bool
+	// Casing of the keys, caseCamel or caseSnake
+	Case string
+	// Indent the JSON for reading it during development
+	Pretty bool
+	// Send lists of books as a bookTable
+	Table bool
+}
+
+// Casings of the keys in JSON responses. The structs are written in
+// camelCase; snake_case is derived from it when a response is sent.
+const (
+	caseCamel = "camelCase"
+	caseSnake = "snake_case"
+)
+
+// Parses the JSON_CASE setting
+func parseJSONCase(value string) (string, error) {
+	switch value {
+	case caseCamel, caseSnake:
+		return value, nil
+	}
+	return "", fmt.Errorf("JSON_CASE: %q must be %s or %s", value, caseCamel, caseSnake)
+}
+
+// An object put together by hand whose keys are field names, such as the
+// meta data of the envelope. They are renamed to the casing of the request
+// like the fields of structs; the keys of other maps are data and are sent
+// as they are.
+type fields map[string]interface{}
+
+// Uniform body sent when the envelope is requested
+type envelope struct {
+	Data  interface{}    `json:"data"`
+	Meta  fields         `json:"meta"`
+	Error *envelopeError `json:"error"`
+}
+
+type envelopeError struct {
+	Code    string     `json:"code,omitempty"`
+	Message string     `json:"message"`
+	Errors  []apiError `json:"errors,omitempty"`
+}
+
+// Decides once per request how the /api responses are written. The envelope
+// is used when it is enabled for everyone (RESPONSE_ENVELOPE) or when the
+// client asks for it with Accept: application/json; profile="envelope".
+// The keys are written in the casing of JSON_CASE unless the client asks
+// for the other one with the profile "camelCase" or "snake_case". The JSON
+// is indented with PRETTY_JSON, which ?pretty=true and ?pretty=false
+// override. Lists of books are sent as a table with ?format=aoa or the
+// profile "aoa".
+func responseMiddleware(envelopeByDefault bool, defaultCase string, prettyByDefault bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			opts := responseOptions{
+				Envelope: envelopeByDefault || acceptsProfile(c, "envelope"),
+				Case:     defaultCase,
+				Pretty:   prettyByDefault,
+				Table:    c.QueryParam("format") == "aoa" || acceptsProfile(c, "aoa"),
+			}
+			if pretty, err := strconv.ParseBool(c.QueryParam("pretty")); err == nil {
+				opts.Pretty = pretty
+			}
+			if acceptsProfile(c, caseSnake) {
+				opts.Case = caseSnake
+			} else if acceptsProfile(c, caseCamel) {
+				opts.Case = caseCamel
+			}
+			c.Set(responseOptionsKey, opts)
+			return next(c)
+		}
+	}
+}
+
+func optionsOf(c echo.Context) responseOptions {
+	opts, _ := c.Get(responseOptionsKey).(responseOptions)
+	return opts
+}
+
+// Reports whether one of the JSON media ranges in the Accept header carries
+// the given profile parameter.
+func acceptsProfile(c echo.Context, profile string) bool {
+	for _, part := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || (mediaType != echo.MIMEApplicationJSON && mediaType != "*/*") {
+			continue
+		}
+		for _, p := range strings.Fields(params["profile"]) {
+			if p == profile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the Accept header names application/json itself, as
+// opposed to accepting anything. Used by routes that answer in another
+// format unless the client asks for JSON.
+func acceptsJSON(c echo.Context) bool {
+	for _, part := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == echo.MIMEApplicationJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the return preference the client sent in the Prefer header
+// (RFC 7240): "minimal" or "representation", the latter also being the
+// default. An honored minimal preference is confirmed in
+// Preference-Applied.
+func preferredReturn(c echo.Context) string {
+	for _, header := range c.Request().Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			token, _, _ := strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(strings.TrimSpace(token), "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "return") {
+				continue
+			}
+			if strings.Trim(strings.TrimSpace(value), `"`) == "minimal" {
+				c.Response().Header().Set("Preference-Applied", "return=minimal")
+				return "minimal"
+			}
+			return "representation"
+		}
+	}
+	return "representation"
+}
+
+// Writes data as the JSON response of an /api handler. Every handler goes
+// through here (or respondError) so the body looks the same everywhere.
+func respond(c echo.Context, code int, data interface{}) error {
+	return respondMeta(c, code, data, nil)
+}
+
+// Like respond, with additional meta data for the envelope, e.g. pagination.
+// Lists always report their length as meta.count.
+func respondMeta(c echo.Context, code int, data interface{}, meta map[string]interface{}) error {
+	opts := optionsOf(c)
+	if !opts.Envelope {
+		return writeJSON(c, code, tabulate(opts, data))
+	}
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+		meta["count"] = v.Len()
+	}
+	return writeJSON(c, code, envelope{Data: tabulate(opts, data), Meta: meta})
+}
+
+// Turns a list of books into a table if the client asked for one. Anything
+// else is sent as it is.
+func tabulate(opts responseOptions, data interface{}) interface{} {
+	if books, ok := data.([]Book); ok && opts.Table {
+		table := tabulateBooks(books)
+		if opts.Case == caseSnake {
+			table.Columns = make([]string, len(bookColumns))
+			for i, column := range bookColumns {
+				table.Columns[i] = snakeCase(column)
+			}
+		}
+		return table
+	}
+	return data
+}
+
+// Answers a request that changed something. Clients that sent Prefer:
+// return=minimal get minimal, the little they cannot do without such as
+// the new version of a book, or no body at all when it is nil: 201 stays
+// 201, anything else becomes 204. Everybody else gets the representation.
+func respondChanged(c echo.Context, code int, representation, minimal interface{}, meta map[string]interface{}) error {
+	if preferredReturn(c) != "minimal" {
+		return respondMeta(c, code, representation, meta)
+	}
+	if minimal != nil {
+		return respondMeta(c, code, minimal, meta)
+	}
+	if code != 201 {
+		code = 204
+	}
+	return c.NoContent(code)
+}
+
+// Path of a book for the Location header of the responses that create one
+func bookLocation(c echo.Context, basePath string, id primitive.ObjectID) {
+	c.Response().Header().Set(echo.HeaderLocation, basePath+"/api/books/"+id.Hex())
+}
+
+// Writes an error. Without the envelope the body is the bare message, as it
+// always has been. Errors clients are expected to handle go through
+// respondProblem instead.
+func respondError(c echo.Context, code int, message string) error {
+	if !optionsOf(c).Envelope {
+		return writeJSON(c, code, message)
+	}
+	return writeJSON(c, code, envelope{
+		Meta:  map[string]interface{}{},
+		Error: &envelopeError{Message: message},
+	})
+}
+
+// Writes a localized error with its code, in the language of the client.
+// Details are the individual problems behind it, e.g. of a validation.
+func respondProblem(c echo.Context, code int, p problem, details ...problem) error {
+	lang := language(c)
+	body := apiError{Code: p.Code, Message: p.message(lang)}
+	for _, detail := range details {
+		body.Errors = append(body.Errors, apiError{Code: detail.Code, Message: detail.message(lang)})
+	}
+	if !optionsOf(c).Envelope {
+		return writeJSON(c, code, body)
+	}
+	return writeJSON(c, code, envelope{
+		Meta:  map[string]interface{}{},
+		Error: &envelopeError{Code: body.Code, Message: body.Message, Errors: body.Errors},
+	})
+}
+
+// Meta data carrying the warnings of checkBook for the envelope, nil if
+// there are none
+func warningsMeta(c echo.Context, warnings []problem) map[string]interface{} {
+	if len(warnings) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"warnings": messages(warnings, language(c))}
+}
+
+// Answers a request with a book that failed validateBook
+func respondInvalid(c echo.Context, problems []problem) error {
+	return respondProblem(c, 422, newProblem("invalid_book"), problems...)
+}
+
+// Sends v as JSON with its keys in the casing of the request, indented if
+// it asked for that. Echo's c.JSON is not used as it indents whenever
+// ?pretty is present, whatever its value.
+func writeJSON(c echo.Context, code int, v interface{}) error {
+	indent := ""
+	if optionsOf(c).Pretty {
+		indent = "  "
+	}
+	if optionsOf(c).Case != caseSnake {
+		return c.JSONPretty(code, v, indent)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return err
+	}
+	return c.JSONPretty(code, renameKeys(reflect.ValueOf(v), generic, snakeCase), indent)
+}
+
+// Applies rename to the keys of the objects in decoded, the JSON of source,
+// that stand for struct fields or come from fields. Keys of other maps,
+// such as author names or years, are left as they are.
+func renameKeys(source reflect.Value, decoded interface{}, rename func(string) string) interface{} {
+	for source.Kind() == reflect.Interface || source.Kind() == reflect.Pointer {
+		if source.IsNil() {
+			return decoded
+		}
+		source = source.Elem()
+	}
+	switch decoded := decoded.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(decoded))
+		switch source.Kind() {
+		case reflect.Struct:
+			values := jsonFields(source)
+			for key, value := range decoded {
+				renamed[rename(key)] = renameKeys(values[key], value, rename)
+			}
+		case reflect.Map:
+			byName := source.Type() == reflect.TypeOf(fields{})
+			for key, value := range decoded {
+				var item reflect.Value
+				if source.Type().Key().Kind() == reflect.String {
+					item = source.MapIndex(reflect.ValueOf(key).Convert(source.Type().Key()))
+				}
+				if byName {
+					key = rename(key)
+				}
+				renamed[key] = renameKeys(item, value, rename)
+			}
+		default:
+			return decoded
+		}
+		return renamed
+	case []interface{}:
+		if source.Kind() != reflect.Slice && source.Kind() != reflect.Array {
+			return decoded
+		}
+		for i, value := range decoded {
+			if i < source.Len() {
+				decoded[i] = renameKeys(source.Index(i), value, rename)
+			}
+		}
+	}
+	return decoded
+}
+
+// The fields of a struct by their JSON name, those of embedded structs
+// included
+func jsonFields(v reflect.Value) map[string]reflect.Value {
+	values := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			embedded := v.Field(i)
+			if embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for key, value := range jsonFields(embedded) {
+					if _, ok := values[key]; !ok {
+						values[key] = value
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		values[name] = v.Field(i)
+	}
+	return values
+}
+
+// "avgPages" becomes "avg_pages". Digits stay attached to the word before
+// them, so "isbn13" is left as it is.
+func snakeCase(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
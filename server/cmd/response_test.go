@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Sends data the way the /api handlers do and returns the body
+func respondTestBody(t *testing.T, opts responseOptions, data interface{}, meta fields) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/books", nil), rec)
+	c.Set(responseOptionsKey, opts)
+	if err := respondMeta(c, 200, data, meta); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(rec.Body.String())
+}
+
+// Only the keys that are field names change their casing, not those that
+// are data such as the author names of a map
+func TestSnakeCaseRenamesFieldsOnly(t *testing.T) {
+	type authorStats struct {
+		BookCount int            `json:"bookCount"`
+		ByAuthor  map[string]int `json:"byAuthor"`
+	}
+	type report struct {
+		authorStats
+		TopAuthor *authorStats `json:"topAuthor"`
+		Changed   fields       `json:"changed"`
+	}
+	data := []report{{
+		authorStats: authorStats{BookCount: 2, ByAuthor: map[string]int{"McCarthy": 2}},
+		TopAuthor:   &authorStats{BookCount: 1, ByAuthor: map[string]int{"LeGuin": 1}},
+		Changed:     fields{"displayOrder": 3},
+	}}
+
+	got := respondTestBody(t, responseOptions{Case: caseSnake}, data, nil)
+	want := `[{"book_count":2,"by_author":{"McCarthy":2},"changed":{"display_order":3},"top_author":{"book_count":1,"by_author":{"LeGuin":1}}}]`
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	got = respondTestBody(t, responseOptions{Case: caseSnake, Envelope: true}, data[0].ByAuthor, fields{"nextOffset": 10})
+	want = `{"data":{"McCarthy":2},"error":null,"meta":{"next_offset":10}}`
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	got = respondTestBody(t, responseOptions{}, data[0].Changed, nil)
+	if want = `{"displayOrder":3}`; got != want {
+		t.Errorf("camelCase got %s, want %s", got, want)
+	}
+}
+
+func TestTableColumnsFollowTheCasing(t *testing.T) {
+	books := []Book{{Name: "Blood Meridian", Author: "McCarthy"}}
+	snake := respondTestBody(t, responseOptions{Case: caseSnake, Table: true}, books, nil)
+	if !strings.Contains(snake, `"display_order","original_isbn"]`) || !strings.Contains(snake, `"McCarthy"`) {
+		t.Errorf("snake_case table %s", snake)
+	}
+	camel := respondTestBody(t, responseOptions{Table: true}, books, nil)
+	if !strings.Contains(camel, `"displayOrder","originalIsbn"]`) {
+		t.Errorf("camelCase table %s", camel)
+	}
+	if bookColumns[12] != "displayOrder" {
+		t.Errorf("the snake_case table changed bookColumns: %q", bookColumns)
+	}
+}
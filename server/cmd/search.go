@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Name of the text index the search runs on. A collection can only have
+// one text index, so fields are added to this one.
+const searchIndexName = "search"
+
+// Creates the text index over the fields a search looks at. Creating an
+// index that already exists with the same definition does nothing; one
+// with other fields has to be dropped first. The title counts most.
+func prepareSearchIndex(coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "bookname", Value: "text"},
+			{Key: "booksubtitle", Value: "text"},
+			{Key: "bookauthor", Value: "text"},
+		},
+		Options: options.Index().
+			SetName(searchIndexName).
+			SetWeights(bson.D{
+				{Key: "bookname", Value: 10},
+				{Key: "booksubtitle", Value: 5},
+				{Key: "bookauthor", Value: 3},
+			}),
+	})
+	return err
+}
+
+// A search hit with the relevance MongoDB computed for it from the weights
+// of the index
+type scoredBook struct {
+	Book
+	Score float64 `json:"score"`
+	// Name and author with the words of the query marked, with ?highlight=true
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// Returns the books matching the words of query in their name, subtitle or
+// author, the best matches first
+func searchBooks(ctx context.Context, coll *mongo.Collection, query string, limit int64) ([]scoredBook, error) {
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+	cursor, err := coll.Find(ctx, bson.M{"$text": bson.M{"$search": query}}, opts, findTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		BookStore `bson:",inline"`
+		Score     float64 `bson:"score"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	books := make([]scoredBook, 0, len(results))
+	for _, res := range results {
+		books = append(books, scoredBook{Book: convertToBook(res.BookStore), Score: res.Score})
+	}
+	return books, nil
+}
+
+// Marks the words of the query in the name and author of the hits, like
+// the text index matches them: regardless of case, and with a word of the
+// query matching the words that start with it, so "frank" marks
+// "Frankenstein". The stemming of the index is not repeated, a hit may thus
+// have no marks at all. The text is HTML-escaped, pre and post are not, so
+// the result can be inserted into a page as it is.
+func highlightBooks(books []scoredBook, query, pre, post string) {
+	terms := searchTerms(query)
+	for i, book := range books {
+		highlights := map[string]string{}
+		for field, text := range map[string]string{"name": book.Name, "author": book.Author} {
+			if marked, ok := highlight(text, terms, pre, post); ok {
+				highlights[field] = marked
+			}
+		}
+		if len(highlights) > 0 {
+			books[i].Highlights = highlights
+		}
+	}
+}
+
+// The words of a search query in lower case. Words excluded with "-" are
+// left out, as they cannot be in a hit.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		terms = append(terms, strings.FieldsFunc(strings.ToLower(word), isNotWordRune)...)
+	}
+	return terms
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Escapes text and wraps the words matching one of terms into pre and
+// post. Reports whether anything was marked.
+func highlight(text string, terms []string, pre, post string) (string, bool) {
+	var b strings.Builder
+	marked := false
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		end := start + 1
+		if isNotWordRune(runes[start]) {
+			for end < len(runes) && isNotWordRune(runes[end]) {
+				end++
+			}
+			b.WriteString(html.EscapeString(string(runes[start:end])))
+			start = end
+			continue
+		}
+		for end < len(runes) && !isNotWordRune(runes[end]) {
+			end++
+		}
+		word := string(runes[start:end])
+		if matchesTerm(strings.ToLower(word), terms) {
+			b.WriteString(pre + html.EscapeString(word) + post)
+			marked = true
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		start = end
+	}
+	return b.String(), marked
+}
+
+func matchesTerm(word string, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Keeps track of the requests that are currently being served, so a
+// shutdown can report what it is waiting for.
+type inFlight struct {
+	mu     sync.Mutex
+	active map[*http.Request]string
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{active: map[*http.Request]string{}}
+}
+
+func (f *inFlight) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			f.mu.Lock()
+			f.active[req] = req.Method + " " + c.Path()
+			f.mu.Unlock()
+			defer func() {
+				f.mu.Lock()
+				delete(f.active, req)
+				f.mu.Unlock()
+			}()
+			return next(c)
+		}
+	}
+}
+
+// Returns the routes of the active requests, one entry per request
+func (f *inFlight) routes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes := make([]string, 0, len(f.active))
+	for _, route := range f.active {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Stops accepting connections and waits up to timeout for the requests in
+// flight to complete. Whatever is still running at the deadline is logged,
+// which points at the endpoints that hang during a deploy.
+func shutdownServer(e *echo.Echo, requests *inFlight, timeout time.Duration) {
+	logger.Info("shutting down",
+		"in_flight", len(requests.routes()),
+		"timeout", timeout.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := e.Shutdown(ctx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Warn("shutdown deadline reached before all requests completed",
+			"still_active", requests.routes())
+	case err != nil:
+		logger.Error("shutdown failed", "error", err)
+	default:
+		logger.Info("all requests completed")
+	}
+}
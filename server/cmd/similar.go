@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Largest number of similar books a request may ask for
+const maxSimilarBooks = 50
+
+// Points a candidate gets for each thing it has in common with the book
+const (
+	similarAuthorScore = 4
+	similarDecadeScore = 2
+	similarTagScore    = 1
+)
+
+// A book together with how similar it is to the one asked about
+type similarBook struct {
+	Book
+	Score int `json:"score"`
+}
+
+// Returns up to limit other books that share the author, the decade or
+// tags with book, the most similar first. The same author counts most,
+// then the same decade, then every shared tag; ties go by name and id.
+// The database scores and sorts all related books, so a prolific author
+// or a busy decade cannot crowd out the best matches.
+func findSimilarBooks(ctx context.Context, coll *mongo.Collection, book BookStore, limit int) ([]similarBook, error) {
+	related := bson.A{authorFilter(book.BookAuthor)}
+	score := bson.A{bson.M{"$cond": bson.A{
+		bson.M{"$regexMatch": bson.M{"input": bson.M{"$ifNull": bson.A{"$bookauthor", ""}}, "regex": authorPattern(book.BookAuthor), "options": "i"}},
+		similarAuthorScore, 0,
+	}}}
+	if book.BookYear != 0 {
+		decade := book.BookYear - book.BookYear%10
+		related = append(related, bson.M{"bookyear": bson.M{"$gte": decade, "$lte": decade + 9}})
+		score = append(score, bson.M{"$cond": bson.A{
+			bson.M{"$and": bson.A{bson.M{"$gte": bson.A{"$bookyear", decade}}, bson.M{"$lte": bson.A{"$bookyear", decade + 9}}}},
+			similarDecadeScore, 0,
+		}})
+	}
+	if len(book.BookTags) > 0 {
+		related = append(related, bson.M{"booktags": bson.M{"$in": book.BookTags}})
+		shared := bson.M{"$size": bson.M{"$setIntersection": bson.A{bson.M{"$ifNull": bson.A{"$booktags", bson.A{}}}, book.BookTags}}}
+		score = append(score, bson.M{"$multiply": bson.A{shared, similarTagScore}})
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$ne": book.ID}, "$or": related}}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$add": score}}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "score", Value: -1},
+			{Key: "bookname", Value: 1},
+			{Key: "_id", Value: 1},
+		}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var scored []struct {
+		BookStore `bson:",inline"`
+		Score     int `bson:"score"`
+	}
+	if err = cursor.All(ctx, &scored); err != nil {
+		return nil, err
+	}
+
+	similar := make([]similarBook, 0, len(scored))
+	for _, candidate := range scored {
+		similar = append(similar, similarBook{Book: convertToBook(candidate.BookStore), Score: candidate.Score})
+	}
+	return similar, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Aggregated page statistics for a single author, as returned by
+// /api/stats/pages-by-author
+type authorPages struct {
+	Author     string  `json:"author" bson:"_id"`
+	TotalPages int     `json:"totalPages" bson:"totalPages"`
+	AvgPages   float64 `json:"avgPages" bson:"avgPages"`
+	BookCount  int     `json:"bookCount" bson:"bookCount"`
+}
+
+// Groups the collection by author and lets the database sum and average the
+// pages. Books without a (positive) page count still count as a book of the
+// author, but they add nothing to the total and are left out of the average
+// instead of pulling it towards zero; negative counts of legacy records
+// would otherwise lower both. The result is ordered by the total number of
+// pages, highest first.
+func pagesByAuthor(ctx context.Context, coll *mongo.Collection) ([]authorPages, error) {
+	pages := bson.M{"$max": bson.A{bson.M{"$ifNull": bson.A{"$bookpages", 0}}, 0}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":        bson.M{"$ifNull": bson.A{"$bookauthor", ""}},
+			"totalPages": bson.M{"$sum": pages},
+			"avgPages": bson.M{"$avg": bson.M{
+				"$cond": bson.A{bson.M{"$gt": bson.A{pages, 0}}, pages, nil},
+			}},
+			"bookCount": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"totalPages": 1,
+			"avgPages":   bson.M{"$ifNull": bson.A{"$avgPages", 0}},
+			"bookCount":  1,
+		}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "totalPages", Value: -1},
+			{Key: "_id", Value: 1},
+		}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	results := []authorPages{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// An author with the number of their books, as returned by /api/authors/top
+type authorCount struct {
+	Author    string `json:"author" bson:"_id"`
+	BookCount int    `json:"bookCount" bson:"bookCount"`
+}
+
+// Largest number of authors /api/authors/top returns
+const maxTopAuthors = 100
+
+// Returns the limit authors with the most books. Authors with the same
+// number of books are ordered by name, so the result is the same on every
+// request. Books without an author are not counted.
+func topAuthors(ctx context.Context, coll *mongo.Collection, limit int) ([]authorCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookauthor": bson.M{"$nin": bson.A{nil, ""}}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookauthor", "bookCount": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "bookCount", Value: -1},
+			{Key: "_id", Value: 1},
+		}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	results := []authorCount{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Number of books published in a single year
+type yearCount struct {
+	Year  int `json:"year" bson:"_id"`
+	Count int `json:"count" bson:"count"`
+}
+
+// Widest range of years a histogram may cover, which bounds the response
+const maxHistogramYears = 5000
+
+var errHistogramTooWide = fmt.Errorf("the histogram may span at most %d years", maxHistogramYears)
+
+// Counts the books per year between from and to (both inclusive) and fills
+// the years without any book with zero, so the result has exactly one entry
+// per year in ascending order. A nil bound is taken from the data instead.
+// Books without a year are left out, they would stretch the histogram back
+// to year 0.
+func booksPerYear(ctx context.Context, coll *mongo.Collection, from, to *int) ([]yearCount, error) {
+	if from != nil && to != nil && *to-*from >= maxHistogramYears {
+		return nil, errHistogramTooWide
+	}
+	years := bson.M{"$nin": bson.A{nil, 0}}
+	if from != nil {
+		years["$gte"] = *from
+	}
+	if to != nil {
+		years["$lte"] = *to
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookyear": years}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookyear", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var counts []yearCount
+	if err = cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	if len(counts) == 0 && (from == nil || to == nil) {
+		return []yearCount{}, nil
+	}
+	first, last := 0, 0
+	if len(counts) > 0 {
+		first, last = counts[0].Year, counts[len(counts)-1].Year
+	}
+	if from != nil {
+		first = *from
+	}
+	if to != nil {
+		last = *to
+	}
+	if last-first >= maxHistogramYears {
+		return nil, errHistogramTooWide
+	}
+
+	histogram := make([]yearCount, 0, last-first+1)
+	next := 0
+	for year := first; year <= last; year++ {
+		entry := yearCount{Year: year}
+		if next < len(counts) && counts[next].Year == year {
+			entry.Count = counts[next].Count
+			next++
+		}
+		histogram = append(histogram, entry)
+	}
+	return histogram, nil
+}
+
+// Number of books whose author's name starts with Initial and that were
+// published in Decade, as returned by /api/stats/author-decade
+type initialDecadeCount struct {
+	Initial string `json:"initial" bson:"initial"`
+	Decade  int    `json:"decade" bson:"decade"`
+	Count   int    `json:"count" bson:"count"`
+}
+
+// Counts the books per first letter of the author and decade in a single
+// $group on both, so a heatmap does not need the whole catalog. The list is
+// flat, one entry per combination that has books, ordered by initial and
+// decade. Books without an author or a year are left out.
+func booksPerInitialAndDecade(ctx context.Context, coll *mongo.Collection) ([]initialDecadeCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"bookauthor": bson.M{"$nin": bson.A{nil, ""}},
+			"bookyear":   bson.M{"$nin": bson.A{nil, 0}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"initial": bson.M{"$toUpper": bson.M{"$substrCP": bson.A{bson.M{"$trim": bson.M{"input": "$bookauthor"}}, 0, 1}}},
+				"decade":  bson.M{"$subtract": bson.A{"$bookyear", bson.M{"$mod": bson.A{"$bookyear", 10}}}},
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "initial": "$_id.initial", "decade": "$_id.decade", "count": 1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "initial", Value: 1}, {Key: "decade", Value: 1}}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	results := []initialDecadeCount{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Optional fields of a book whose completeness is measured, and the
+// expression telling whether a document has the field filled in
+var completenessFields = []struct {
+	name   string
+	filled bson.M
+}{
+	{"isbn", nonEmptyString("$bookisbn")},
+	{"year", bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$bookyear", 0}}, 0}}},
+	{"pages", bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$bookpages", 0}}, 0}}},
+	{"subtitle", nonEmptyString("$booksubtitle")},
+	{"edition", nonEmptyString("$bookedition")},
+	{"cover", nonEmptyString("$bookcover")},
+	{"tags", bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$booktags", bson.A{}}}}, 0}}},
+}
+
+func nonEmptyString(field string) bson.M {
+	return bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{field, ""}}, ""}}
+}
+
+// How many books have one of the optional fields filled in
+type fieldCompleteness struct {
+	Field   string  `json:"field"`
+	Books   int     `json:"books"`
+	Percent float64 `json:"percent"`
+}
+
+// Completeness of the catalog, as returned by /api/stats/completeness. The
+// score is the average of the percentages of the fields. Percentages are
+// rounded to one decimal.
+type completenessReport struct {
+	Books  int                 `json:"books"`
+	Score  float64             `json:"score"`
+	Fields []fieldCompleteness `json:"fields"`
+}
+
+// Counts in a single $group how many books have each optional field filled
+// in. An empty catalog scores 0.
+func catalogCompleteness(ctx context.Context, coll *mongo.Collection) (completenessReport, error) {
+	group := bson.M{"_id": nil, "books": bson.M{"$sum": 1}}
+	for _, field := range completenessFields {
+		group[field.name] = bson.M{"$sum": bson.M{"$cond": bson.A{field.filled, 1, 0}}}
+	}
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$group", Value: group}}}, aggregateTimeLimit(ctx))
+	if err != nil {
+		return completenessReport{}, err
+	}
+	var results []map[string]interface{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return completenessReport{}, err
+	}
+
+	report := completenessReport{Fields: make([]fieldCompleteness, 0, len(completenessFields))}
+	counts := map[string]interface{}{}
+	if len(results) > 0 {
+		counts = results[0]
+		report.Books = toInt(counts["books"])
+	}
+	total := 0.0
+	for _, field := range completenessFields {
+		entry := fieldCompleteness{Field: field.name, Books: toInt(counts[field.name])}
+		if report.Books > 0 {
+			percent := 100 * float64(entry.Books) / float64(report.Books)
+			entry.Percent = math.Round(10*percent) / 10
+			total += percent
+		}
+		report.Fields = append(report.Fields, entry)
+	}
+	report.Score = math.Round(10*total/float64(len(completenessFields))) / 10
+	return report, nil
+}
+
+// The driver decodes the sums as int32 or int64 depending on their size
+func toInt(value interface{}) int {
+	switch v := value.(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// Most prefixes GET /api/stats/isbn-prefix may ask for
+const maxISBNPrefixes = 100
+
+// Number of books whose ISBN starts with Prefix, the ISBN-13 prefix and
+// registration group such as "978-3"
+type isbnPrefixCount struct {
+	Prefix string `json:"prefix"`
+	Books  int    `json:"books"`
+}
+
+// Books by ISBN prefix. Unassigned counts the books with a valid ISBN of a
+// group isbnGroupLengths does not know; books without a valid ISBN are not
+// counted at all.
+type isbnPrefixReport struct {
+	Prefixes   []isbnPrefixCount `json:"prefixes"`
+	Unassigned int               `json:"unassigned"`
+}
+
+// Counts the books per registration group of their ISBN, the largest
+// groups first, and returns the top limit of them. ISBN-10s count as their
+// ISBN-13. The database cannot tell the groups apart, so the ISBNs are
+// streamed and grouped here. Publishers are not split off, as their
+// ranges differ per group and are not kept.
+func booksPerISBNPrefix(ctx context.Context, coll *mongo.Collection, limit int) (isbnPrefixReport, error) {
+	report := isbnPrefixReport{Prefixes: []isbnPrefixCount{}}
+	opts := options.Find().SetProjection(bson.M{"bookisbn": 1}).SetBatchSize(isbnScanBatchSize)
+	cursor, err := coll.Find(ctx, bson.M{"bookisbn": bson.M{"$nin": bson.A{nil, ""}}}, opts, findTimeLimit(ctx))
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := map[string]int{}
+	for cursor.Next(ctx) {
+		var book BookStore
+		if err := cursor.Decode(&book); err != nil {
+			return report, err
+		}
+		info := describeISBN(book.BookISBN)
+		if !info.Valid {
+			continue
+		}
+		group, ok := isbnGroup(info.ISBN13)
+		if !ok {
+			report.Unassigned++
+			continue
+		}
+		counts[info.ISBN13[:3]+"-"+group]++
+	}
+	if err := cursor.Err(); err != nil {
+		return report, err
+	}
+
+	for prefix, books := range counts {
+		report.Prefixes = append(report.Prefixes, isbnPrefixCount{Prefix: prefix, Books: books})
+	}
+	sort.Slice(report.Prefixes, func(i, j int) bool {
+		if report.Prefixes[i].Books != report.Prefixes[j].Books {
+			return report.Prefixes[i].Books > report.Prefixes[j].Books
+		}
+		return report.Prefixes[i].Prefix < report.Prefixes[j].Prefix
+	})
+	if len(report.Prefixes) > limit {
+		report.Prefixes = report.Prefixes[:limit]
+	}
+	return report, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How often a stream is reopened after a transient error before it gives
+// up, and how long it waits before trying again
+const (
+	streamRetries    = 5
+	streamRetryPause = time.Second
+)
+
+// A change as decoded from the change stream
+type changeEvent struct {
+	ID            bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	DocumentKey   struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *BookStore `bson:"fullDocument"`
+}
+
+// What a client of /api/books/stream receives for a change. Deleted books
+// only carry their id, the database does not keep anything else of them.
+type streamEvent struct {
+	ID   string `json:"id"`
+	Book *Book  `json:"book,omitempty"`
+}
+
+// Actions the operations of the change stream are sent as
+var streamActions = map[string]string{
+	"insert":  actionCreated,
+	"update":  actionUpdated,
+	"replace": actionUpdated,
+	"delete":  actionDeleted,
+}
+
+// Opens a change stream on the books, resuming after token if it is set
+func watchBooks(ctx context.Context, coll *mongo.Collection, token bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+	return coll.Watch(ctx, pipeline, opts)
+}
+
+// Resume token of a change stream from the Last-Event-ID a reconnecting
+// client sends, nil if there is none
+func resumeToken(lastEventID string) bson.Raw {
+	if lastEventID == "" {
+		return nil
+	}
+	token, err := bson.Marshal(bson.M{"_data": lastEventID})
+	if err != nil {
+		return nil
+	}
+	return token
+}
+
+// Pushes the changes of the books to the client as Server-Sent Events
+// until the client goes away or ctx is done. Every event carries the resume
+// token of the change as its id, so a client that reconnects continues
+// where it left off. After a transient error the stream is reopened from
+// the last token, up to streamRetries times in a row.
+func streamBooks(ctx context.Context, c echo.Context, coll *mongo.Collection) error {
+	token := resumeToken(c.Request().Header.Get("Last-Event-ID"))
+	stream, err := watchBooks(ctx, coll, token)
+	if err != nil && token != nil {
+		// The token may be too old to resume from, the client still gets
+		// the changes from now on
+		logger.WarnContext(ctx, "could not resume change stream", "error", err)
+		token = nil
+		stream, err = watchBooks(ctx, coll, nil)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "could not open change stream", "error", err)
+		return respondError(c, 503, "Live updates are not available")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(200)
+	res.Flush()
+
+	failures := 0
+	for {
+		for stream.Next(ctx) {
+			failures = 0
+			var change changeEvent
+			if err := stream.Decode(&change); err != nil {
+				logger.ErrorContext(ctx, "could not decode change", "error", err)
+				continue
+			}
+			token = change.ID
+			if err := writeStreamEvent(c, change); err != nil {
+				stream.Close(context.WithoutCancel(ctx))
+				return nil
+			}
+		}
+		err := stream.Err()
+		stream.Close(context.WithoutCancel(ctx))
+		if ctx.Err() != nil {
+			return nil
+		}
+		failures++
+		if failures > streamRetries {
+			logger.ErrorContext(ctx, "change stream failed", "error", err)
+			return nil
+		}
+		logger.WarnContext(ctx, "change stream interrupted, resuming", "error", err, "attempt", failures)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(streamRetryPause):
+		}
+		if stream, err = watchBooks(ctx, coll, token); err != nil {
+			logger.ErrorContext(ctx, "could not reopen change stream", "error", err)
+			return nil
+		}
+	}
+}
+
+// Writes a change as an event named after its action
+func writeStreamEvent(c echo.Context, change changeEvent) error {
+	action, ok := streamActions[change.OperationType]
+	if !ok {
+		return nil
+	}
+	event := streamEvent{ID: change.DocumentKey.ID.Hex()}
+	if change.FullDocument != nil {
+		book := convertToBook(*change.FullDocument)
+		event.Book = &book
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var id struct {
+		Data string `bson:"_data"`
+	}
+	bson.Unmarshal(change.ID, &id)
+	res := c.Response()
+	if _, err := fmt.Fprintf(res, "id: %s\nevent: %s\ndata: %s\n\n", id.Data, action, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+// Columns of the table format, in the order of the values in every row.
+// New fields of Book are added at the end so positions stay stable.
+var bookColumns = []string{
+	"id", "name", "subtitle", "edition", "author", "isbn",
+	"pages", "year", "cover", "tags", "version", "lock", "displayOrder",
+	"originalIsbn",
+}
+
+// A list of books as a table: the field names once, then one array of
+// values per book. Asked for with ?format=aoa or the Accept profile "aoa"
+// by clients such as data tables, and much smaller than the objects for
+// long lists. Empty fields are in the row as well, as null or their zero
+// value.
+type bookTable struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+func tabulateBooks(books []Book) bookTable {
+	table := bookTable{Columns: bookColumns, Rows: make([][]interface{}, 0, len(books))}
+	for _, b := range books {
+		table.Rows = append(table.Rows, []interface{}{
+			b.ID, b.Name, b.Subtitle, b.Edition, b.Author, b.ISBN,
+			b.Pages, b.Year, b.Cover, b.Tags, b.Version, b.Lock, b.DisplayOrder,
+			b.OriginalISBN,
+		})
+	}
+	return table
+}
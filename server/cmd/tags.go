@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits for the labels curators put on books
+const (
+	maxTagLength   = 30
+	maxTagsPerBook = 20
+)
+
+// Tags are compared in lower case with single spaces, so "Staff  Pick" and
+// "staff pick" are the same tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// Reports the problems of a normalized tag: letters, digits, spaces, "-"
+// and "_" only, and not too long
+func validateTag(tag string) []problem {
+	if tag == "" {
+		return []problem{newProblem("tag_required")}
+	}
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return []problem{newProblem("tag_too_long", tag, maxTagLength)}
+	}
+	for _, r := range tag {
+		if !isTagRune(r) {
+			return []problem{newProblem("tag_invalid", tag, r)}
+		}
+	}
+	return nil
+}
+
+func isTagRune(r rune) bool {
+	return r == ' ' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Adds tags to a book, leaving out those it has already. Like the other
+// changes it counts as a new version of the book; the result is the book as
+// it is stored afterwards, or mongo.ErrNoDocuments when there is no such
+// book.
+func addTags(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, tags []string) (BookStore, error) {
+	update := bson.M{
+		"$addToSet": bson.M{"booktags": bson.M{"$each": tags}},
+		"$inc":      bson.M{"version": 1},
+	}
+	return updateTags(ctx, coll, id, update)
+}
+
+// Removes a tag from a book
+func removeTag(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, tag string) (BookStore, error) {
+	update := bson.M{
+		"$pull": bson.M{"booktags": tag},
+		"$inc":  bson.M{"version": 1},
+	}
+	return updateTags(ctx, coll, id, update)
+}
+
+// Returns the tags out of tags the book does not have yet
+func newTags(book BookStore, tags []string) []string {
+	var added []string
+	for _, tag := range tags {
+		if !slices.Contains(book.BookTags, tag) && !slices.Contains(added, tag) {
+			added = append(added, tag)
+		}
+	}
+	return added
+}
+
+func updateTags(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, update bson.M) (BookStore, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var book BookStore
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&book)
+	return book, err
+}
+
+// Outcome of a bulk tag. Skipped books match the filter but were left
+// alone, as somebody else holds their edit lock, they have maxTagsPerBook
+// tags already or they changed while the tag was added.
+type bulkTagReport struct {
+	Tag      string `json:"tag"`
+	Matched  int    `json:"matched"`
+	Modified int64  `json:"modified"`
+	Skipped  int    `json:"skipped"`
+}
+
+// Bulk tags are refused when the filters match more books than this, which
+// keeps the books read for the history and the ids of the update small
+var errTooManyToTag = fmt.Errorf("at most %d books can be tagged at once, narrow the filters", maxBatchIDs)
+
+// Adds tag to every book matching filter with a single UpdateMany. Each
+// book that gets the tag counts as a new version of it and is recorded in
+// the history, as with a single tag. Books somebody other than owner holds
+// the edit lock of are skipped, and so are books that changed since they
+// were read, as the history could not tell their state before the tag.
+func bulkTagBooks(ctx context.Context, coll, hist *mongo.Collection, webhooks *webhookNotifier, user, owner string, filter bson.M, tag string) (bulkTagReport, error) {
+	report := bulkTagReport{Tag: tag}
+	opts := options.Find().SetLimit(maxBatchIDs + 1)
+	cursor, err := coll.Find(ctx, filter, opts, findTimeLimit(ctx))
+	if err != nil {
+		return report, err
+	}
+	var found []BookStore
+	if err = cursor.All(ctx, &found); err != nil {
+		return report, err
+	}
+	if len(found) > maxBatchIDs {
+		return report, errTooManyToTag
+	}
+	report.Matched = len(found)
+
+	before := map[primitive.ObjectID]BookStore{}
+	var unchanged bson.A
+	for _, book := range found {
+		if slices.Contains(book.BookTags, tag) {
+			continue
+		}
+		if lockedByOther(book, owner) || len(book.BookTags) >= maxTagsPerBook {
+			report.Skipped++
+			continue
+		}
+		before[book.ID] = book
+		version := interface{}(book.Version)
+		if book.Version == 0 {
+			// Books stored before versions were counted have none
+			version = bson.M{"$in": bson.A{0, nil}}
+		}
+		unchanged = append(unchanged, bson.M{"_id": book.ID, "version": version})
+	}
+	if len(unchanged) == 0 {
+		return report, nil
+	}
+	update := bson.M{
+		"$addToSet": bson.M{"booktags": tag},
+		"$inc":      bson.M{"version": 1},
+	}
+	result, err := coll.UpdateMany(ctx, bson.M{"$or": unchanged, "$and": bson.A{unlockedFilter(owner, time.Now().UTC())}}, update)
+	if err != nil {
+		return report, err
+	}
+	report.Modified = result.ModifiedCount
+	report.Skipped += len(unchanged) - int(result.ModifiedCount)
+
+	// The history gets the books as they are stored now. A book the update
+	// left alone still has its old version.
+	ids := make([]primitive.ObjectID, 0, len(before))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	cursor, err = coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var after BookStore
+		if err := cursor.Decode(&after); err != nil {
+			return report, err
+		}
+		if old := before[after.ID]; after.Version > old.Version && slices.Contains(after.BookTags, tag) {
+			recordAudit(ctx, hist, webhooks, actionUpdated, user, &old, &after)
+		}
+	}
+	return report, cursor.Err()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+// Book data must come out of every table view as text, however much it
+// looks like markup
+func TestTablesEscapeBookData(t *testing.T) {
+	tmpl, err := template.New("").Funcs(templateFuncs).ParseGlob("../views/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []Book{{
+		ID:     "6acfbdef17d728e2108ee8f8",
+		Name:   `<script>alert("name")</script>`,
+		Author: `<img src=x onerror=alert(1)>`,
+		ISBN:   `"><script>alert("isbn")</script>`,
+		Pages:  100,
+		Year:   1924,
+	}}
+
+	for _, view := range []string{"book-table", "author-table", "year-table"} {
+		t.Run(view, func(t *testing.T) {
+			var out strings.Builder
+			if err := tmpl.ExecuteTemplate(&out, view, books); err != nil {
+				t.Fatal(err)
+			}
+			html := out.String()
+			for _, raw := range []string{"<script", "<img"} {
+				if strings.Contains(html, raw) {
+					t.Errorf("%s renders %q unescaped:\n%s", view, raw, html)
+				}
+			}
+		})
+	}
+
+	var out strings.Builder
+	if err := tmpl.ExecuteTemplate(&out, "book-row", books[0]); err != nil {
+		t.Fatal(err)
+	}
+	if want := "&lt;script&gt;alert(&#34;name&#34;)&lt;/script&gt;"; !strings.Contains(out.String(), want) {
+		t.Errorf("book-row does not show the name as text %s:\n%s", want, out.String())
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	year := templateFuncs["year"].(func(int) string)
+	truncate := templateFuncs["truncate"].(func(int, string) string)
+	optional := templateFuncs["optional"].(func(string) string)
+
+	tests := []struct {
+		name, got, want string
+	}{
+		{"year", year(1924), "1924"},
+		{"missing year", year(0), "–"},
+		{"short title", truncate(10, "Dune"), "Dune"},
+		{"long title", truncate(10, "The Left Hand of Darkness"), "The Left…"},
+		{"multibyte title", truncate(4, "Érase una vez"), "Éra…"},
+		{"filled in", optional("958-30-0804-4"), "958-30-0804-4"},
+		{"blank", optional("  "), "–"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, test.got, test.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Largest number of books /api/books/oldest, /newest and /near-year return
+const maxRankedBooks = 100
+
+// A book together with its position in the timeline, starting at 1
+type rankedBook struct {
+	Rank int `json:"rank"`
+	Book
+}
+
+// Index the oldest and newest books are read from. It holds the name as
+// well so books of the same year come out in a stable order.
+func prepareYearIndex(coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "bookyear", Value: 1}, {Key: "bookname", Value: 1}},
+		Options: options.Index().SetName("year"),
+	})
+	return err
+}
+
+// Returns the limit oldest books, or the newest ones if newest is set,
+// ranked by year and then by name. Books without a year are left out.
+func rankBooksByYear(ctx context.Context, coll *mongo.Collection, newest bool, limit int64) ([]rankedBook, error) {
+	direction := 1
+	if newest {
+		direction = -1
+	}
+	opts := options.Find().
+		SetSort(stableSort(bson.D{{Key: "bookyear", Value: direction}, {Key: "bookname", Value: 1}})).
+		SetLimit(limit)
+	books, err := findBooks(ctx, coll, bson.M{"bookyear": bson.M{"$nin": bson.A{0, nil}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	ranked := make([]rankedBook, 0, len(books))
+	for i, book := range books {
+		ranked = append(ranked, rankedBook{Rank: i + 1, Book: book})
+	}
+	return ranked, nil
+}
+
+// A book together with how many years its publication lies from the year
+// that was asked for
+type nearBook struct {
+	Distance int `json:"distance"`
+	Book
+}
+
+// Returns the limit books published closest to year, by the absolute
+// difference of the years and then by name. Books without a year are left
+// out.
+func findBooksNearYear(ctx context.Context, coll *mongo.Collection, year int, limit int64) ([]nearBook, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookyear": bson.M{"$nin": bson.A{0, nil}}}}},
+		{{Key: "$addFields", Value: bson.M{
+			"distance": bson.M{"$abs": bson.M{"$subtract": bson.A{"$bookyear", year}}},
+		}}},
+		{{Key: "$sort", Value: stableSort(bson.D{{Key: "distance", Value: 1}, {Key: "bookname", Value: 1}})}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, aggregateTimeLimit(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		BookStore `bson:",inline"`
+		Distance  int `bson:"distance"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	books := make([]nearBook, 0, len(results))
+	for _, res := range results {
+		books = append(books, nearBook{Distance: res.Distance, Book: convertToBook(res.BookStore)})
+	}
+	return books, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+type dbTimingKey struct{}
+
+// Time a single request spent waiting for the database. The driver reports
+// every command it runs, and the commands of a request add up here.
+type dbTiming struct {
+	nanos    atomic.Int64
+	commands atomic.Int64
+}
+
+func (t *dbTiming) add(d time.Duration) {
+	t.nanos.Add(int64(d))
+	t.commands.Add(1)
+}
+
+// Returns the timing stored in ctx by serverTimingMiddleware, or nil for
+// database calls made outside of a request
+func dbTimingFrom(ctx context.Context) *dbTiming {
+	t, _ := ctx.Value(dbTimingKey{}).(*dbTiming)
+	return t
+}
+
+// Command monitor for the client options that attributes the duration of
+// every database command to the request it was made for
+func dbTimingMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			if t := dbTimingFrom(ctx); t != nil {
+				t.add(e.Duration)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			if t := dbTimingFrom(ctx); t != nil {
+				t.add(e.Duration)
+			}
+		},
+	}
+}
+
+// Adds a Server-Timing header to the responses of the routes below
+// apiPrefix, splitting the time spent so far into the part waiting for
+// MongoDB and the total. Browsers show it next to the network timings in
+// their developer tools.
+func serverTimingMiddleware(apiPrefix string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !strings.HasPrefix(c.Path(), apiPrefix) {
+				return next(c)
+			}
+			start := time.Now()
+			timing := &dbTiming{}
+			req := c.Request()
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), dbTimingKey{}, timing)))
+
+			// The header has to be set before the status line goes out,
+			// which is as late as the numbers can be taken
+			c.Response().Before(func() {
+				c.Response().Header().Set("Server-Timing", fmt.Sprintf(
+					"db;dur=%.1f;desc=\"MongoDB, %d commands\", total;dur=%.1f",
+					milliseconds(time.Duration(timing.nanos.Load())), timing.commands.Load(),
+					milliseconds(time.Since(start))))
+			})
+			return next(c)
+		}
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Outcome of POST /api/books/upsert
+type upsertResult struct {
+	ID      string `json:"id"`
+	Created bool   `json:"created"`
+}
+
+// Stores book under its ISBN. existing is the book found for the ISBN
+// beforehand, which is updated in place, or mongo.ErrNoDocuments returned
+// if it is gone by now. Without one the book is inserted, unless a book
+// with the very same ISBN spelling was stored meanwhile, which is then
+// updated instead. Returns the id of the book and whether it was inserted.
+func upsertBook(ctx context.Context, coll *mongo.Collection, existing *BookStore, book BookStore) (primitive.ObjectID, bool, error) {
+	filter := bson.M{"bookisbn": book.BookISBN}
+	update := bson.M{"$set": editableFields(book)}
+	if existing != nil {
+		filter = bson.M{"_id": existing.ID}
+		update["$inc"] = bson.M{"version": 1}
+	} else {
+		update["$setOnInsert"] = bson.M{"createdat": time.Now().UTC()}
+	}
+	res, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(existing == nil))
+	if err != nil {
+		return primitive.NilObjectID, false, err
+	}
+	if id, ok := res.UpsertedID.(primitive.ObjectID); ok {
+		return id, true, nil
+	}
+	if existing != nil {
+		if res.MatchedCount == 0 {
+			// Deleted since it was found
+			return existing.ID, false, mongo.ErrNoDocuments
+		}
+		return existing.ID, false, nil
+	}
+	var updated BookStore
+	err = coll.FindOne(ctx, filter).Decode(&updated)
+	return updated.ID, false, err
+}
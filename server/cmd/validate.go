@@ -0,0 +1,79 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Editions are written in many ways ("2", "2nd", "Second revised ed.",
+// "3. Auflage"), so this only keeps out what is obviously not one
+var editionPattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} .,'()/-]{0,39}$`)
+
+// Checks a book before it is stored and returns everything that is wrong
+// with it. An empty result means the book is fine.
+func validateBook(book Book) []problem {
+	var problems []problem
+	if strings.TrimSpace(book.Name) == "" {
+		problems = append(problems, newProblem("name_required"))
+	}
+	if strings.TrimSpace(book.Author) == "" {
+		problems = append(problems, newProblem("author_required"))
+	}
+	for _, field := range []struct{ name, value string }{
+		{"name", book.Name}, {"subtitle", book.Subtitle}, {"edition", book.Edition},
+		{"author", book.Author}, {"isbn", book.ISBN},
+	} {
+		if i := strings.IndexFunc(field.value, unicode.IsControl); i >= 0 {
+			problems = append(problems, newProblem("control_characters", field.name, []rune(field.value[i:])[0], i))
+		}
+	}
+	if book.Edition != "" && !editionPattern.MatchString(book.Edition) {
+		problems = append(problems, newProblem("edition_invalid"))
+	}
+	if book.Pages < 0 {
+		problems = append(problems, newProblem("pages_negative"))
+	}
+	if book.Year > time.Now().Year()+1 {
+		problems = append(problems, newProblem("year_in_future"))
+	}
+	return problems
+}
+
+// Printing with movable type in Europe started around 1450; books claiming
+// to be older are mostly typos
+const earliestLikelyYear = 1450
+
+// Things about a book that are odd but not wrong. They are reported so the
+// data can be reviewed later, the book is stored anyway.
+func bookWarnings(book Book) []problem {
+	var warnings []problem
+	if name := strings.TrimSpace(book.Name); name != "" && utf8.RuneCountInString(name) < 3 {
+		warnings = append(warnings, newProblem("name_short"))
+	}
+	if book.ISBN != "" && !isValidISBN(book.ISBN) {
+		warnings = append(warnings, newProblem("isbn_invalid", book.ISBN))
+	}
+	if book.Year != 0 && book.Year < earliestLikelyYear {
+		warnings = append(warnings, newProblem("year_suspicious", book.Year))
+	}
+	return warnings
+}
+
+// Validates a book and collects its warnings. In strict mode, which clients
+// ask for with ?strict=true, warnings count as errors.
+func checkBook(book Book, strict bool) (errors, warnings []problem) {
+	errors, warnings = validateBook(book), bookWarnings(book)
+	if strict {
+		return append(errors, warnings...), nil
+	}
+	return errors, warnings
+}
+
+func strictValidation(c echo.Context) bool {
+	return c.QueryParam("strict") == "true"
+}
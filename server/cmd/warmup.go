@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Longest a warm-up may delay the start of the server
+const warmupTimeout = time.Minute
+
+// Reads the whole catalog once before the server accepts requests, so the
+// database has the books and the _id index in memory instead of loading
+// them from disk on the first requests after a deploy, and counts the books
+// for /metrics and the quota. The server keeps no cache of its own, the
+// documents are read and dropped one by one. A failed warm-up is logged
+// and the server starts anyway.
+func warmUp(ctx context.Context, coll *mongo.Collection, counter *bookCounter) {
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+
+	counter.refresh(ctx, coll)
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		logger.Warn("warm-up failed", "error", err)
+		return
+	}
+	defer cursor.Close(ctx)
+	read := 0
+	for cursor.Next(ctx) {
+		read++
+	}
+	if err := cursor.Err(); err != nil {
+		logger.Warn("warm-up failed", "books", read, "error", err)
+		return
+	}
+	logger.Info("warm-up done", "books", read, "duration", time.Since(started).String())
+}
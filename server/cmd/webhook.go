@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Header carrying the HMAC-SHA256 of the body, keyed with WEBHOOK_SECRET
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+// Number of events that may wait for delivery. Events beyond it are
+// dropped rather than holding up the requests that caused them.
+const webhookQueueSize = 1000
+
+// Body posted to the webhook for every change of a book. The book is the
+// stored one for created and updated books and the last version of a
+// deleted one.
+type webhookEvent struct {
+	Event string    `json:"event"`
+	At    time.Time `json:"at"`
+	Book  Book      `json:"book"`
+}
+
+// Posts the changes of the catalog to WEBHOOK_URL. Events are queued and
+// sent one after the other in the background, so a slow receiver delays
+// the notifications but never the API.
+type webhookNotifier struct {
+	url      string
+	secret   []byte
+	client   *http.Client
+	attempts int
+	events   chan webhookEvent
+}
+
+// Returns a notifier that gives every delivery timeout and tries it
+// retries more times when it fails
+func newWebhookNotifier(url, secret string, timeout time.Duration, retries int) *webhookNotifier {
+	return &webhookNotifier{
+		url:      url,
+		secret:   []byte(secret),
+		client:   &http.Client{Timeout: timeout},
+		attempts: retries + 1,
+		events:   make(chan webhookEvent, webhookQueueSize),
+	}
+}
+
+// Queues the event for a change of book. Does nothing when no webhook is
+// configured.
+func (n *webhookNotifier) notify(ctx context.Context, action string, book BookStore) {
+	if n == nil {
+		return
+	}
+	event := webhookEvent{Event: action, At: time.Now().UTC(), Book: convertToBook(book)}
+	select {
+	case n.events <- event:
+	default:
+		logger.WarnContext(ctx, "webhook queue full, event dropped",
+			"event", action, "book", book.ID.Hex())
+	}
+}
+
+// Delivers the queued events until ctx is done. Events that are still
+// queued then are logged as lost.
+func (n *webhookNotifier) start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if n == nil {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				if len(n.events) > 0 {
+					logger.Warn("webhook events not delivered before shutdown", "events", len(n.events))
+				}
+				return
+			case event := <-n.events:
+				n.deliver(ctx, event)
+			}
+		}
+	}()
+	return done
+}
+
+// Posts the event, retrying with a growing pause when the receiver cannot
+// be reached or does not answer with 2xx
+func (n *webhookNotifier) deliver(ctx context.Context, event webhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("could not encode webhook event", "error", err)
+		return
+	}
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	pause := time.Second
+	for attempt := 1; ; attempt++ {
+		err = n.post(ctx, body, event.Event, signature)
+		if err == nil {
+			return
+		}
+		if attempt == n.attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pause):
+		}
+		pause *= 2
+	}
+	logger.Error("webhook delivery failed",
+		"event", event.Event, "book", event.Book.ID, "attempts", n.attempts, "error", err)
+}
+
+func (n *webhookNotifier) post(ctx context.Context, body []byte, event, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set(webhookSignatureHeader, signature)
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with %d", res.StatusCode)
+	}
+	return nil
+}
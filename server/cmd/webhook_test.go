@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The notifier built by newServer posts the changes of books, signed with
+// WEBHOOK_SECRET
+func TestWebhookDelivery(t *testing.T) {
+	type delivery struct {
+		event, signature string
+		body             []byte
+	}
+	received := make(chan delivery, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{r.Header.Get("X-Webhook-Event"), r.Header.Get(webhookSignatureHeader), body}
+	}))
+	defer receiver.Close()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DisableHTML = true
+	cfg.WebhookURL, cfg.WebhookSecret = receiver.URL, "secret"
+	_, _, webhooks, err := newServer(cfg, nil, nil, nil, &bookCounter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := webhooks.start(ctx)
+	defer func() { cancel(); <-done }()
+
+	id, _ := primitive.ObjectIDFromHex("6acfbdef17d728e2108ee8f8")
+	webhooks.notify(ctx, actionCreated, BookStore{ID: id, BookName: "Frankenstein", BookAuthor: "Mary Shelley"})
+
+	select {
+	case d := <-received:
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(d.body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); d.signature != want {
+			t.Errorf("signature %q, want %q", d.signature, want)
+		}
+		var event webhookEvent
+		if err := json.Unmarshal(d.body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if d.event != actionCreated || event.Event != actionCreated || event.Book.ID != id.Hex() || event.Book.Name != "Frankenstein" {
+			t.Errorf("got event %q with %+v", d.event, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivered")
+	}
+}
@@ -3,8 +3,12 @@ module github.com/CAPS-Cloud/exercises
 go 1.22.0
 
 require (
-	github.com/gogo/protobuf v1.3.2
+	github.com/andybalholm/brotli v1.1.1
 	github.com/labstack/echo/v4 v4.12.0
+	go.mongodb.org/mongo-driver v1.15.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/text v0.14.0
+	golang.org/x/time v0.5.0
 )
 
 require (
@@ -21,11 +25,8 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	go.mongodb.org/mongo-driver v1.15.0 // indirect
 	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/net v0.24.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )